@@ -18,9 +18,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -28,6 +32,7 @@ import (
 
 	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
 	"github.com/ibrokethecloud/vault-glue-operator/pkg/controllers"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -46,10 +51,30 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var clusterName string
+	var defaultChart vaultv1alpha1.ChartSpec
+	var allowedChartRepositories string
+	var chartCacheDir string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&clusterName, "cluster-name", "",
+		"Name of this cluster used in vault auth mount paths. Defaults to a prefix of the kube-system namespace UID.")
+	flag.StringVar(&defaultChart.Repository, "chart-repository", "",
+		"HTTP chart repository or oci:// reference of the external-secrets chart. Defaults to the bundled chart.")
+	flag.StringVar(&defaultChart.Name, "chart-name", "", "Name of the chart in the HTTP chart repository.")
+	flag.StringVar(&defaultChart.Version, "chart-version", "", "Version of the chart. Defaults to the bundled version.")
+	flag.StringVar(&defaultChart.Path, "chart-path", "", "Chart tarball used instead of the bundled chart.")
+	flag.StringVar(&defaultChart.Digest, "chart-digest", "", "Expected sha256:<hex> digest of the chart archive.")
+	flag.StringVar(&defaultChart.CredentialsSecret, "chart-credentials-secret", "",
+		"Secret in the operator namespace with username, password and ca.crt for the chart repository.")
+	flag.StringVar(&defaultChart.KeyringSecret, "chart-keyring-secret", "",
+		"Secret in the operator namespace with the keyring the chart provenance is verified against.")
+	flag.StringVar(&allowedChartRepositories, "allowed-chart-repositories", "",
+		"Comma separated repository URLs Registers may install their chart from, matched on scheme, host and path prefix.")
+	flag.StringVar(&chartCacheDir, "chart-cache-dir", filepath.Join(os.TempDir(), "charts"),
+		"Directory charts downloaded from repositories are cached in.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
@@ -60,20 +85,44 @@ func main() {
 		Port:               9443,
 		LeaderElection:     enableLeaderElection,
 		LeaderElectionID:   "2e5b4954.io",
+		NewClient:          controllers.NewClient,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	helmClient := helm.NewClient(mgr.GetConfig(), mgr.GetRESTMapper())
+	helmLog := ctrl.Log.WithName("helm")
+	helmClient.Log = func(format string, v ...interface{}) {
+		helmLog.V(1).Info(fmt.Sprintf(format, v...))
+	}
+
 	if err = (&controllers.RegisterReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Register"),
-		Scheme: mgr.GetScheme(),
+		Client:         mgr.GetClient(),
+		Log:            ctrl.Log.WithName("controllers").WithName("Register"),
+		Scheme:         mgr.GetScheme(),
+		Recorder:       mgr.GetEventRecorderFor("vault-glue-operator"),
+		ClusterName:    clusterName,
+		KubeClient:     kubernetes.NewForConfigOrDie(mgr.GetConfig()),
+		RestConfigHost: mgr.GetConfig().Host,
+		Helm:           helmClient,
+		DefaultChart:   defaultChart,
+		// an empty flag allows no repositories
+		AllowedChartRepositories: strings.Split(allowedChartRepositories, ","),
+		ChartCacheDir:            chartCacheDir,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Register")
 		os.Exit(1)
 	}
+	// the webhooks are turned off where no serving certificate is available
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		vaultv1alpha1.OperatorServiceAccount = os.Getenv("SERVICE_ACCOUNT")
+		if err = (&vaultv1alpha1.Register{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Register")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -0,0 +1,85 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ConditionTokenAvailable is true when the operator has credentials to login to vault
+	ConditionTokenAvailable = "TokenAvailable"
+	// ConditionServiceAccountReady is true when the service account bound to the role exists
+	ConditionServiceAccountReady = "ServiceAccountReady"
+	// ConditionVaultAuthConfigured is true when the auth mount, its config and the roles are written
+	ConditionVaultAuthConfigured = "VaultAuthConfigured"
+	// ConditionExternalSecretsInstalled is true when the external-secrets chart is installed
+	ConditionExternalSecretsInstalled = "ExternalSecretsInstalled"
+	// ConditionReady is true when every step has completed for the current generation
+	ConditionReady = "Ready"
+	// ConditionDrifted is true when the last drift check found vault out of sync with the spec
+	ConditionDrifted = "Drifted"
+	// ConditionStalled is true when the last attempt failed in a way retrying cannot fix.
+	// Reconciling resumes once the spec changes
+	ConditionStalled = "Stalled"
+	// ConditionLoginVerified is true when the bound service account could login to the auth mount.
+	// A failure carries the error returned by vault, e.g. when vault cannot reach the api server
+	ConditionLoginVerified = "LoginVerified"
+)
+
+// Condition mirrors metav1.Condition, which is not available in the apimachinery version in use
+type Condition struct {
+	Type               string                 `json:"type"`
+	Status             metav1.ConditionStatus `json:"status"`
+	ObservedGeneration int64                  `json:"observedGeneration,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+	Reason             string                 `json:"reason"`
+	Message            string                 `json:"message"`
+}
+
+// SetCondition adds or updates a condition. LastTransitionTime only moves when the status changes
+func (in *RegisterStatus) SetCondition(condition Condition) {
+	existing := in.GetCondition(condition.Type)
+	if existing == nil {
+		if condition.LastTransitionTime.IsZero() {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		in.Conditions = append(in.Conditions, condition)
+		return
+	}
+
+	if existing.Status != condition.Status {
+		existing.Status = condition.Status
+		existing.LastTransitionTime = condition.LastTransitionTime
+		if existing.LastTransitionTime.IsZero() {
+			existing.LastTransitionTime = metav1.Now()
+		}
+	}
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
+	existing.ObservedGeneration = condition.ObservedGeneration
+}
+
+// GetCondition returns the condition of the given type or nil
+func (in *RegisterStatus) GetCondition(conditionType string) *Condition {
+	for i := range in.Conditions {
+		if in.Conditions[i].Type == conditionType {
+			return &in.Conditions[i]
+		}
+	}
+	return nil
+}
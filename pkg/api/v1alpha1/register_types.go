@@ -17,7 +17,10 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -25,31 +28,300 @@ import (
 
 // RegisterSpec defines the desired state of Register
 type RegisterSpec struct {
-	VaultAddr                    string   `json:"vaultAddr"`
-	ServiceAccount               string   `json:"serviceAccount"`
-	Namespace                    string   `json:"namespace"`
+	// +kubebuilder:validation:Pattern=`^https?://[^/]+`
+	VaultAddr      string `json:"vaultAddr"`
+	VaultNamespace string `json:"vaultNamespace,omitempty"` //vault enterprise/hcp namespace
+	// +kubebuilder:validation:MaxLength=253
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	ServiceAccount string `json:"serviceAccount"`
+	// Namespace the service account is created and the chart installed in. Defaults to the
+	// namespace of the Register
+	// +optional
+	// +kubebuilder:validation:MaxLength=63
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Namespace string `json:"namespace,omitempty"`
+	// +kubebuilder:validation:MinItems=1
 	VaultPolicy                  []string `json:"vaultPolicy"`
 	VaultCACert                  string   `json:"vaultCACert,omitempty"`
 	SkipExternalSecretInstall    bool     `json:"skipExternalSecretInstall,omitempty"`
 	ExternalSecretNamespaceWatch []string `json:"externalSecretNamespaceWatch,omitempty"`
 	SSLDisable                   bool     `json:"sslDisable,omitempty"`
 	K8SEndpoint                  string   `json:"k8sEndpoint,omitempty"` //to provide an externally loadbalanced k8s endpoint
-	RoleName                     string   `json:"roleName"`
+	// K8SEndpointStrategy selects how kubernetes_host is discovered, one of Explicit,
+	// KubernetesService, OperatorConfig, ClusterInfo or NodeLabels. Defaults to Explicit when
+	// k8sEndpoint is set and NodeLabels otherwise
+	// +kubebuilder:validation:Enum=Explicit;KubernetesService;OperatorConfig;ClusterInfo;NodeLabels
+	K8SEndpointStrategy string `json:"k8sEndpointStrategy,omitempty"`
+	// K8SEndpointPort is the api server port used with the NodeLabels strategy. Defaults to 6443
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=6443
+	K8SEndpointPort int32 `json:"k8sEndpointPort,omitempty"`
+	// RoleName is the primary vault role. Defaults to the name of the Register
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+	// Role configures the token settings of the vault role
+	Role *RoleSpec `json:"role,omitempty"`
+	// Roles are additional roles created on the same cluster auth mount
+	Roles []VaultRoleSpec `json:"roles,omitempty"`
+	// DriftCheckInterval is how often vault is compared against the spec once processed.
+	// Defaults to 10m, 0s disables drift checks
+	// +kubebuilder:default="10m"
+	DriftCheckInterval *metav1.Duration `json:"driftCheckInterval,omitempty"`
+	// VaultAuth configures how the operator authenticates to vault. Defaults to the vault-token secret
+	VaultAuth *VaultAuthSpec `json:"vaultAuth,omitempty"`
+	// VaultTLS configures the operators tls connection to vault
+	VaultTLS *VaultTLSSpec `json:"vaultTLS,omitempty"`
+	// TokenReviewer configures the JWT vault uses to review the tokens of logins
+	TokenReviewer *TokenReviewerSpec `json:"tokenReviewer,omitempty"`
+	// MountPath is the path of the kubernetes auth mount in vault. Takes precedence over MountPathTemplate
+	MountPath string `json:"mountPath,omitempty"`
+	// MountPathTemplate derives the mount path from {{clusterName}}, {{namespace}} and {{registerName}}.
+	// Defaults to k8s-{{clusterName}}-{{namespace}}-{{registerName}}
+	MountPathTemplate string `json:"mountPathTemplate,omitempty"`
+	// Chart selects the external-secrets chart. Defaults to the chart configured for the operator,
+	// which is the chart bundled with the operator unless set with the --chart-* flags
+	Chart *ChartSpec `json:"chart,omitempty"`
+	// SecretsBackend is the chart installed into the namespace to consume secrets,
+	// KubernetesExternalSecrets, ExternalSecretsOperator, VaultAgentInjector or VaultCSIProvider.
+	// Defaults to KubernetesExternalSecrets
+	// +kubebuilder:validation:Enum=KubernetesExternalSecrets;ExternalSecretsOperator;VaultAgentInjector;VaultCSIProvider
+	SecretsBackend string `json:"secretsBackend,omitempty"`
+	// SecretStore configures the store generated for the ExternalSecretsOperator backend
+	SecretStore *SecretStoreSpec `json:"secretStore,omitempty"`
+	// SecretProviderClass configures the class generated for the VaultCSIProvider backend
+	SecretProviderClass *SecretProviderClassSpec `json:"secretProviderClass,omitempty"`
+	// HelmValues are merged over the values generated for the chart and any HelmValuesFrom
+	// +kubebuilder:pruning:PreserveUnknownFields
+	HelmValues *runtime.RawExtension `json:"helmValues,omitempty"`
+	// HelmValuesFrom are merged over the generated values in order, each one taking precedence
+	// over the previous ones
+	HelmValuesFrom []ValuesReference `json:"helmValuesFrom,omitempty"`
+	// OverrideProtectedValues lists the values set by the operator, e.g. env.VAULT_ADDR, that
+	// HelmValues and HelmValuesFrom may replace. Others are always reset to the generated value
+	OverrideProtectedValues []string `json:"overrideProtectedValues,omitempty"`
+}
+
+// ValuesReference points at a key of a ConfigMap or Secret in the namespace of the Register
+// holding helm values
+type ValuesReference struct {
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	Kind string `json:"kind"`
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// Key defaults to values.yaml
+	Key string `json:"key,omitempty"`
+	// Optional references are skipped when the object or key does not exist
+	Optional bool `json:"optional,omitempty"`
+}
+
+const (
+	EndpointStrategyExplicit          = "Explicit"
+	EndpointStrategyKubernetesService = "KubernetesService"
+	EndpointStrategyOperatorConfig    = "OperatorConfig"
+	EndpointStrategyClusterInfo       = "ClusterInfo"
+	EndpointStrategyNodeLabels        = "NodeLabels"
+)
+
+// MinTokenExpiration is the shortest reviewer token lifetime the TokenRequest API accepts
+const MinTokenExpiration = 10 * time.Minute
+
+// RoleSpec defines the token settings written to auth/<mount>/role/<roleName>
+type RoleSpec struct {
+	// TokenTTL defaults to 24h
+	TokenTTL        string   `json:"tokenTTL,omitempty"`
+	TokenMaxTTL     string   `json:"tokenMaxTTL,omitempty"`
+	TokenPeriod     string   `json:"tokenPeriod,omitempty"`
+	TokenBoundCIDRs []string `json:"tokenBoundCIDRs,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	TokenNumUses int `json:"tokenNumUses,omitempty"`
+	// TokenType is one of default, service or batch
+	// +kubebuilder:validation:Enum=default;service;batch
+	TokenType string `json:"tokenType,omitempty"`
+	Audience  string `json:"audience,omitempty"`
+	// AliasNameSource is one of serviceaccount_uid or serviceaccount_name
+	// +kubebuilder:validation:Enum=serviceaccount_uid;serviceaccount_name
+	AliasNameSource string `json:"aliasNameSource,omitempty"`
+}
+
+// TokenReviewerSpec defines the service account vault uses to call the TokenReview API
+type TokenReviewerSpec struct {
+	// ServiceAccount in the operator namespace, bound to system:auth-delegator by the operator.
+	// Defaults to <namespace>-<name>-vault-reviewer
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// OmitJWT leaves token_reviewer_jwt unset so vault reviews each login with the JWT being
+	// logged in with. The workload service accounts then need system:auth-delegator themselves
+	OmitJWT bool `json:"omitJWT,omitempty"`
+	// TokenExpiration switches to short lived reviewer tokens from the TokenRequest API.
+	// Unset uses a long lived token secret. Must be at least 10m
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	TokenExpiration *metav1.Duration `json:"tokenExpiration,omitempty"`
+	// RenewBefore is how long before expiry the reviewer token is renewed. Defaults to
+	// a fifth of TokenExpiration and must be shorter than it
+	// +kubebuilder:validation:Type=string
+	// +kubebuilder:validation:Pattern=`^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+}
+
+// SecretStoreSpec defines the external secrets operator store using the cluster auth mount
+type SecretStoreSpec struct {
+	// Kind is SecretStore, in the namespace of the service account, or ClusterSecretStore.
+	// Defaults to SecretStore
+	// +kubebuilder:validation:Enum=SecretStore;ClusterSecretStore
+	Kind string `json:"kind,omitempty"`
+	// Name defaults to the name of the Register
+	Name string `json:"name,omitempty"`
+	// Path is the mount of the KV secrets engine. Defaults to secret
+	Path string `json:"path,omitempty"`
+	// Version of the KV secrets engine, v1 or v2. Defaults to v2
+	// +kubebuilder:validation:Enum=v1;v2
+	Version string `json:"version,omitempty"`
+}
+
+// SecretProviderClassSpec defines the SecretProviderClass using the cluster auth mount and role
+type SecretProviderClassSpec struct {
+	// Name defaults to the name of the Register
+	Name string `json:"name,omitempty"`
+	// Objects lists the secrets to mount in the format of the vault CSI provider. The class is
+	// generated as a template to complete when empty
+	Objects string `json:"objects,omitempty"`
+}
+
+// ChartSpec defines where the external-secrets chart is loaded from. Secrets are read from the
+// namespace the operator runs in
+type ChartSpec struct {
+	// Path is a chart tarball next to the bundled chart, e.g. from a mounted volume
+	Path string `json:"path,omitempty"`
+	// Repository is an HTTP chart repository or an OCI reference such as
+	// oci://registry.example.com/charts/kubernetes-external-secrets
+	// +kubebuilder:validation:Pattern=`^(https?|oci)://`
+	Repository string `json:"repository,omitempty"`
+	// Name of the chart in an HTTP repository. Defaults to kubernetes-external-secrets
+	Name string `json:"name,omitempty"`
+	// Version of the chart. Defaults to the version bundled with the operator
+	Version string `json:"version,omitempty"`
+	// CredentialsSecret has the username and password keys and an optional ca.crt for the repository
+	CredentialsSecret string `json:"credentialsSecret,omitempty"`
+	// Digest is the sha256 of the chart archive as sha256:<hex>
+	// +kubebuilder:validation:Pattern=`^sha256:[a-f0-9]{64}$`
+	Digest string `json:"digest,omitempty"`
+	// KeyringSecret has a keyring key with the public keys the provenance file of the chart must
+	// be signed with
+	KeyringSecret string `json:"keyringSecret,omitempty"`
+}
+
+// VaultRoleSpec defines an additional role on the cluster auth mount
+type VaultRoleSpec struct {
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+	// +kubebuilder:validation:MinItems=1
+	ServiceAccounts []string `json:"serviceAccounts"`
+	// +kubebuilder:validation:MinItems=1
+	Namespaces []string `json:"namespaces"`
+	// +kubebuilder:validation:MinItems=1
+	Policies []string `json:"policies"`
+	RoleSpec `json:",inline"`
+}
+
+// VaultTLSSpec defines how the operator verifies and authenticates to the vault server.
+// Secrets are read from the namespace the operator runs in
+type VaultTLSSpec struct {
+	// CASecret is a secret with a ca.crt key, used when vaultCACert is not set
+	CASecret string `json:"caSecret,omitempty"`
+	// ClientCertSecret is a kubernetes.io/tls secret used for mTLS to vault
+	ClientCertSecret string `json:"clientCertSecret,omitempty"`
+	// ServerName overrides the server name used to verify the vault certificate
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// VaultAuthSpec defines how the operator logs in to vault. Secrets are always
+// read from the namespace the operator runs in
+type VaultAuthSpec struct {
+	// Method is one of token, kubernetes or approle
+	// +kubebuilder:validation:Enum=token;kubernetes;approle
+	// +kubebuilder:default=token
+	Method string `json:"method,omitempty"`
+	// SecretName holds the token (token method) or secret_id (approle method)
+	SecretName string `json:"secretName,omitempty"`
+	// Mount is the vault auth mount used for kubernetes and approle logins
+	Mount string `json:"mount,omitempty"`
+	// Role is the vault role used for kubernetes logins
+	Role string `json:"role,omitempty"`
+	// RoleID is the approle role_id
+	RoleID string `json:"roleID,omitempty"`
 }
 
 // RegisterStatus defines the observed state of Register
 type RegisterStatus struct {
-	Status         string `json:"status"`
+	// LegacyStatus is the progress recorded by versions before conditions were added. It is
+	// only read to carry processed Registers over to the conditions
+	LegacyStatus   string `json:"status,omitempty"`
 	VaultAuthMount string `json:"vaultAuthPath"`
 	HelmStatus     string `json:"helmStatus"`
-	Message        string `json:"message"`
+	// Roles reports the state of each role managed on the auth mount
+	Roles []RoleStatus `json:"roles,omitempty"`
+	// ObservedGeneration is the generation of the spec last applied
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Applied records the spec values in use so renames can be cleaned up
+	Applied *AppliedSpec `json:"applied,omitempty"`
+	// LastDriftCheck is when vault was last compared against the spec
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+	// Attempts counts the consecutive failed reconcile attempts
+	Attempts int32 `json:"attempts,omitempty"`
+	// NextRetry is when the last failed attempt is retried. It is not set for errors
+	// which need a spec change
+	NextRetry *metav1.Time `json:"nextRetry,omitempty"`
+	// Conditions are updated independently by each step of the reconcile
+	Conditions []Condition `json:"conditions,omitempty"`
+}
+
+// AppliedSpec defines the spec values last applied by the operator
+type AppliedSpec struct {
+	VaultAddr      string `json:"vaultAddr,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	// ReviewerServiceAccount is the token reviewer in the operator namespace
+	ReviewerServiceAccount string `json:"reviewerServiceAccount,omitempty"`
+	// K8SHost is the kubernetes_host written to the auth config
+	K8SHost string `json:"k8sHost,omitempty"`
+	// K8SEndpointStrategy is the strategy K8SHost was discovered with
+	K8SEndpointStrategy string `json:"k8sEndpointStrategy,omitempty"`
+	// ReviewerChecksum identifies the reviewer JWT and CA written to the auth config
+	ReviewerChecksum string `json:"reviewerChecksum,omitempty"`
+	// ReviewerTokenExpiry is when the short lived reviewer token written to vault expires
+	ReviewerTokenExpiry *metav1.Time `json:"reviewerTokenExpiry,omitempty"`
+	HelmValuesChecksum  string       `json:"helmValuesChecksum,omitempty"`
+	// SecretsBackend is the backend whose chart is installed
+	SecretsBackend string `json:"secretsBackend,omitempty"`
+	// BackendObjects are the objects created for the backend next to its chart
+	BackendObjects []BackendObject `json:"backendObjects,omitempty"`
+}
+
+// BackendObject references an object created for the secrets backend
+type BackendObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+}
+
+// RoleStatus defines the observed state of a vault role
+type RoleStatus struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
 }
 
 // +kubebuilder:object:root=true
-// +kubebuilder:printcolumn:name="RegisterStatus",type=string,JSONPath=`.status.status`
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=vreg,categories=vault
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
 // +kubebuilder:printcolumn:name="HelmStatus",type=string,JSONPath=`.status.helmStatus`
 // +kubebuilder:printcolumn:name="VaultMount",type=string,JSONPath=`.status.vaultAuthPath`
-// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.message`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].message`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // Register is the Schema for the registers API
 type Register struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -0,0 +1,355 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var registerlog = logf.Log.WithName("register-resource")
+
+// OperatorServiceAccount is the service account the operator runs as, which a Register
+// must never use as its reviewer. Set from $SERVICE_ACCOUNT by main
+var OperatorServiceAccount string
+
+func (r *Register) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-vault-cattle-io-v1alpha1-register,mutating=true,failurePolicy=fail,groups=vault.cattle.io,resources=registers,verbs=create;update,versions=v1alpha1,name=mregister.kb.io
+
+var _ webhook.Defaulter = &Register{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type.
+// The role is named after the Register, and the service account is created and the chart
+// installed in the namespace of the Register
+func (r *Register) Default() {
+	registerlog.Info("default", "name", r.Name)
+	r.SetDefaults()
+}
+
+// SetDefaults fills in the optional fields the same way as the webhook. The controller
+// applies it too, as Registers may be created without the webhook
+func (r *Register) SetDefaults() {
+	if len(r.Spec.RoleName) == 0 {
+		r.Spec.RoleName = r.Name
+	}
+	if len(r.Spec.Namespace) == 0 {
+		r.Spec.Namespace = r.Namespace
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-vault-cattle-io-v1alpha1-register,mutating=false,failurePolicy=fail,groups=vault.cattle.io,resources=registers,versions=v1alpha1,name=vregister.kb.io
+
+var _ webhook.Validator = &Register{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Register) ValidateCreate() error {
+	registerlog.Info("validate create", "name", r.Name)
+
+	return r.invalid(r.validateSpec())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Register) ValidateUpdate(old runtime.Object) error {
+	registerlog.Info("validate update", "name", r.Name)
+
+	oldRegister, ok := old.(*Register)
+	if !ok {
+		return r.invalid(r.validateSpec())
+	}
+	// metadata only updates, such as the operator removing its finalizer, are always let
+	// through so Registers created before validation existed can still be deleted
+	if r.DeletionTimestamp != nil || reflect.DeepEqual(r.Spec, oldRegister.Spec) {
+		return nil
+	}
+	allErrs := r.validateSpec()
+	allErrs = append(allErrs, r.validateImmutable(oldRegister)...)
+	return r.invalid(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Register) ValidateDelete() error {
+	return nil
+}
+
+func (r *Register) invalid(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Register").GroupKind(), r.Name, allErrs)
+}
+
+func (r *Register) validateSpec() (allErrs field.ErrorList) {
+	specPath := field.NewPath("spec")
+
+	allErrs = append(allErrs, validateVaultAddr(specPath.Child("vaultAddr"), r.Spec.VaultAddr)...)
+	allErrs = append(allErrs, validateName(specPath.Child("serviceAccount"), r.Spec.ServiceAccount,
+		validation.IsDNS1123Subdomain)...)
+	allErrs = append(allErrs, validateName(specPath.Child("namespace"), r.Spec.Namespace,
+		validation.IsDNS1123Label)...)
+	if len(r.Spec.RoleName) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("roleName"), ""))
+	}
+	allErrs = append(allErrs, validatePolicies(specPath.Child("vaultPolicy"), r.Spec.VaultPolicy)...)
+
+	if len(r.Spec.VaultCACert) != 0 && !x509.NewCertPool().AppendCertsFromPEM([]byte(r.Spec.VaultCACert)) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("vaultCACert"), "<pem>",
+			"no PEM encoded certificate found"))
+	}
+	if r.Spec.SSLDisable {
+		if len(r.Spec.VaultCACert) != 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("vaultCACert"),
+				"a CA cannot be used when sslDisable is set"))
+		}
+		if r.Spec.VaultTLS != nil && len(r.Spec.VaultTLS.CASecret) != 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("vaultTLS", "caSecret"),
+				"a CA cannot be used when sslDisable is set"))
+		}
+	}
+
+	if len(r.Spec.MountPath) != 0 {
+		allErrs = append(allErrs, validateMountPath(specPath.Child("mountPath"), r.Spec.MountPath,
+			r.Spec.MountPath)...)
+	}
+	if len(r.Spec.MountPathTemplate) != 0 {
+		allErrs = append(allErrs, validateMountPathTemplate(specPath.Child("mountPathTemplate"),
+			r.Spec.MountPathTemplate)...)
+	}
+	if r.Spec.K8SEndpointStrategy == EndpointStrategyExplicit && len(r.Spec.K8SEndpoint) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("k8sEndpoint"),
+			"required with the Explicit endpoint strategy"))
+	}
+	if r.Spec.TokenReviewer != nil && len(r.Spec.TokenReviewer.ServiceAccount) != 0 {
+		allErrs = append(allErrs, validateName(specPath.Child("tokenReviewer", "serviceAccount"),
+			r.Spec.TokenReviewer.ServiceAccount, validation.IsDNS1123Subdomain)...)
+		if r.Spec.TokenReviewer.ServiceAccount == OperatorServiceAccount {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("tokenReviewer", "serviceAccount"),
+				"the service account of the operator cannot be used as the reviewer"))
+		}
+	}
+	if r.Spec.TokenReviewer != nil {
+		allErrs = append(allErrs, validateTokenLifetime(specPath.Child("tokenReviewer"), r.Spec.TokenReviewer)...)
+	}
+
+	if r.Spec.SecretStore != nil && r.Spec.SecretsBackend != "ExternalSecretsOperator" {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("secretStore"),
+			"a store is only generated for the ExternalSecretsOperator backend"))
+	}
+	if r.Spec.SecretProviderClass != nil && r.Spec.SecretsBackend != "VaultCSIProvider" {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("secretProviderClass"),
+			"a SecretProviderClass is only generated for the VaultCSIProvider backend"))
+	}
+	if r.Spec.SecretsBackend == "ExternalSecretsOperator" || r.Spec.SecretsBackend == "VaultCSIProvider" {
+		allErrs = append(allErrs, r.validateSharedBackend(specPath)...)
+	}
+	if chart := r.Spec.Chart; chart != nil {
+		chartPath := specPath.Child("chart")
+		if len(chart.Path) != 0 && len(chart.Repository) != 0 {
+			allErrs = append(allErrs, field.Forbidden(chartPath.Child("path"),
+				"path and repository are mutually exclusive"))
+		}
+		if strings.HasPrefix(chart.Repository, "oci://") && len(chart.KeyringSecret) != 0 {
+			allErrs = append(allErrs, field.Forbidden(chartPath.Child("keyringSecret"),
+				"provenance is not verified for OCI charts, use a digest"))
+		}
+	}
+
+	if values := r.Spec.HelmValues; values != nil && len(values.Raw) != 0 {
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(values.Raw, &parsed); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("helmValues"), string(values.Raw),
+				"must be an object"))
+		}
+	}
+	for i, ref := range r.Spec.HelmValuesFrom {
+		allErrs = append(allErrs, validateName(specPath.Child("helmValuesFrom").Index(i).Child("name"), ref.Name,
+			validation.IsDNS1123Subdomain)...)
+	}
+	for i, path := range r.Spec.OverrideProtectedValues {
+		if len(path) == 0 || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("overrideProtectedValues").Index(i), path,
+				"must be a dotted path such as env.VAULT_ADDR"))
+		}
+	}
+
+	names := map[string]bool{r.Spec.RoleName: true}
+	for i, role := range r.Spec.Roles {
+		rolePath := specPath.Child("roles").Index(i)
+		if len(role.Name) == 0 {
+			allErrs = append(allErrs, field.Required(rolePath.Child("name"), ""))
+		} else if names[role.Name] {
+			allErrs = append(allErrs, field.Duplicate(rolePath.Child("name"), role.Name))
+		}
+		names[role.Name] = true
+		// vault accepts globs in the bound names, only plain names are checked
+		for j, sa := range role.ServiceAccounts {
+			if !strings.Contains(sa, "*") {
+				allErrs = append(allErrs, validateName(rolePath.Child("serviceAccounts").Index(j), sa,
+					validation.IsDNS1123Subdomain)...)
+			}
+		}
+		for j, namespace := range role.Namespaces {
+			if !strings.Contains(namespace, "*") {
+				allErrs = append(allErrs, validateName(rolePath.Child("namespaces").Index(j), namespace,
+					validation.IsDNS1123Label)...)
+			}
+		}
+		allErrs = append(allErrs, validatePolicies(rolePath.Child("policies"), role.Policies)...)
+	}
+	return allErrs
+}
+
+// validateSharedBackend rejects chart settings for backends whose chart is installed once
+// and shared by every Register
+func (r *Register) validateSharedBackend(specPath *field.Path) (allErrs field.ErrorList) {
+	detail := fmt.Sprintf("the chart of the %s backend is shared by every Register", r.Spec.SecretsBackend)
+	if r.Spec.Chart != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("chart"), detail))
+	}
+	if r.Spec.HelmValues != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("helmValues"), detail))
+	}
+	if len(r.Spec.HelmValuesFrom) != 0 {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("helmValuesFrom"), detail))
+	}
+	if len(r.Spec.OverrideProtectedValues) != 0 {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("overrideProtectedValues"), detail))
+	}
+	return allErrs
+}
+
+// validateImmutable rejects changes the operator cannot follow. The auth mount lives in the
+// vault namespace, so a mount in the previous namespace could not be found again. A changed
+// vaultAddr is followed by moving the auth mount to the new vault
+func (r *Register) validateImmutable(old *Register) (allErrs field.ErrorList) {
+	if r.Spec.VaultNamespace != old.Spec.VaultNamespace {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "vaultNamespace"),
+			"field is immutable"))
+	}
+	return allErrs
+}
+
+func validateVaultAddr(fldPath *field.Path, vaultAddr string) (allErrs field.ErrorList) {
+	if len(vaultAddr) == 0 {
+		return append(allErrs, field.Required(fldPath, ""))
+	}
+	address, err := url.Parse(vaultAddr)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, vaultAddr, err.Error()))
+	}
+	if address.Scheme != "http" && address.Scheme != "https" {
+		allErrs = append(allErrs, field.Invalid(fldPath, vaultAddr, "scheme must be http or https"))
+	}
+	if len(address.Host) == 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath, vaultAddr, "host is required"))
+	}
+	return allErrs
+}
+
+// mountPathVariables are the variables a mountPathTemplate can use
+var mountPathVariables = []string{"{{clusterName}}", "{{namespace}}", "{{registerName}}"}
+
+// validateMountPathTemplate checks the template only uses the known variables and renders
+// to a valid mount path
+func validateMountPathTemplate(fldPath *field.Path, template string) (allErrs field.ErrorList) {
+	rendered := template
+	for _, variable := range mountPathVariables {
+		rendered = strings.ReplaceAll(rendered, variable, "x")
+	}
+	if strings.Contains(rendered, "{") || strings.Contains(rendered, "}") {
+		return append(allErrs, field.Invalid(fldPath, template,
+			"only {{clusterName}}, {{namespace}} and {{registerName}} can be used"))
+	}
+	return validateMountPath(fldPath, template, rendered)
+}
+
+// validateMountPath rejects paths vault would store under a different name than the one the
+// operator looks the mount up by
+func validateMountPath(fldPath *field.Path, value string, path string) (allErrs field.ErrorList) {
+	if strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		return append(allErrs, field.Invalid(fldPath, value, "must not start or end with a slash"))
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if len(strings.TrimSpace(segment)) == 0 {
+			return append(allErrs, field.Invalid(fldPath, value, "must not contain empty segments"))
+		}
+	}
+	return allErrs
+}
+
+// validateTokenLifetime checks the short lived reviewer token is accepted by the TokenRequest
+// API and renewed before it expires
+func validateTokenLifetime(fldPath *field.Path, reviewer *TokenReviewerSpec) (allErrs field.ErrorList) {
+	if reviewer.TokenExpiration != nil && reviewer.TokenExpiration.Duration < MinTokenExpiration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("tokenExpiration"),
+			reviewer.TokenExpiration.Duration.String(), fmt.Sprintf("must be at least %v", MinTokenExpiration)))
+	}
+	if reviewer.RenewBefore == nil {
+		return allErrs
+	}
+	switch {
+	case reviewer.RenewBefore.Duration <= 0:
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewBefore"),
+			reviewer.RenewBefore.Duration.String(), "must be positive"))
+	case reviewer.TokenExpiration == nil:
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("renewBefore"),
+			"only used with tokenExpiration"))
+	case reviewer.RenewBefore.Duration >= reviewer.TokenExpiration.Duration:
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewBefore"),
+			reviewer.RenewBefore.Duration.String(), "must be shorter than tokenExpiration"))
+	}
+	return allErrs
+}
+
+func validateName(fldPath *field.Path, name string, validate func(string) []string) (allErrs field.ErrorList) {
+	if len(name) == 0 {
+		return append(allErrs, field.Required(fldPath, ""))
+	}
+	for _, msg := range validate(name) {
+		allErrs = append(allErrs, field.Invalid(fldPath, name, msg))
+	}
+	return allErrs
+}
+
+func validatePolicies(fldPath *field.Path, policies []string) (allErrs field.ErrorList) {
+	if len(policies) == 0 {
+		return append(allErrs, field.Required(fldPath, "at least one policy is required"))
+	}
+	for i, policy := range policies {
+		if len(strings.TrimSpace(policy)) == 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), policy, "policy name is empty"))
+		}
+	}
+	return allErrs
+}
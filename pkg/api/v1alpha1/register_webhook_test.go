@@ -0,0 +1,190 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func validRegister() *Register {
+	return &Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "apps"},
+		Spec: RegisterSpec{
+			VaultAddr:      "https://vault.example.com:8200",
+			ServiceAccount: "external-secrets",
+			Namespace:      "apps",
+			VaultPolicy:    []string{"apps-read"},
+			RoleName:       "demo",
+		},
+	}
+}
+
+func TestRegisterDefault(t *testing.T) {
+	register := validRegister()
+	register.Spec.Namespace = ""
+	register.Spec.RoleName = ""
+	register.Default()
+	if register.Spec.Namespace != "apps" || register.Spec.RoleName != "demo" {
+		t.Fatalf("unexpected defaults: %+v", register.Spec)
+	}
+}
+
+func TestRegisterValidateCreate(t *testing.T) {
+	OperatorServiceAccount = "vault-glue-operator"
+	defer func() { OperatorServiceAccount = "" }()
+	tests := map[string]struct {
+		mutate func(register *Register)
+		field  string
+	}{
+		"valid":                 {mutate: func(register *Register) {}},
+		"vault address scheme":  {mutate: func(register *Register) { register.Spec.VaultAddr = "vault:8200" }, field: "spec.vaultAddr"},
+		"vault address missing": {mutate: func(register *Register) { register.Spec.VaultAddr = "" }, field: "spec.vaultAddr"},
+		"service account name":  {mutate: func(register *Register) { register.Spec.ServiceAccount = "Bad_Name" }, field: "spec.serviceAccount"},
+		"namespace name":        {mutate: func(register *Register) { register.Spec.Namespace = "apps.example" }, field: "spec.namespace"},
+		"no policies":           {mutate: func(register *Register) { register.Spec.VaultPolicy = nil }, field: "spec.vaultPolicy"},
+		"ca not pem":            {mutate: func(register *Register) { register.Spec.VaultCACert = "not a cert" }, field: "spec.vaultCACert"},
+		"ca with ssl disabled": {mutate: func(register *Register) {
+			register.Spec.SSLDisable = true
+			register.Spec.VaultTLS = &VaultTLSSpec{CASecret: "vault-ca"}
+		}, field: "spec.vaultTLS.caSecret"},
+		"mount path":         {mutate: func(register *Register) { register.Spec.MountPath = "clusters/k8s-prod" }},
+		"mount path slash":   {mutate: func(register *Register) { register.Spec.MountPath = "/k8s-prod" }, field: "spec.mountPath"},
+		"mount path segment": {mutate: func(register *Register) { register.Spec.MountPath = "clusters//prod" }, field: "spec.mountPath"},
+		"mount path template": {mutate: func(register *Register) {
+			register.Spec.MountPathTemplate = "k8s/{{clusterName}}/{{namespace}}-{{registerName}}"
+		}},
+		"mount path template variable": {mutate: func(register *Register) {
+			register.Spec.MountPathTemplate = "k8s-{{cluster}}"
+		}, field: "spec.mountPathTemplate"},
+		"mount path template syntax": {mutate: func(register *Register) {
+			register.Spec.MountPathTemplate = "k8s-{{clusterName}"
+		}, field: "spec.mountPathTemplate"},
+		"mount path template slash": {mutate: func(register *Register) {
+			register.Spec.MountPathTemplate = "k8s/{{clusterName}}/"
+		}, field: "spec.mountPathTemplate"},
+		"explicit without endpoint": {mutate: func(register *Register) {
+			register.Spec.K8SEndpointStrategy = EndpointStrategyExplicit
+		}, field: "spec.k8sEndpoint"},
+		"role glob": {mutate: func(register *Register) {
+			register.Spec.Roles = []VaultRoleSpec{{Name: "apps", ServiceAccounts: []string{"*"},
+				Namespaces: []string{"app-*"}, Policies: []string{"apps-read"}}}
+		}},
+		"role without policies": {mutate: func(register *Register) {
+			register.Spec.Roles = []VaultRoleSpec{{Name: "apps", ServiceAccounts: []string{"default"},
+				Namespaces: []string{"apps"}}}
+		}, field: "spec.roles[0].policies"},
+		"duplicate role": {mutate: func(register *Register) {
+			register.Spec.Roles = []VaultRoleSpec{{Name: "demo", ServiceAccounts: []string{"default"},
+				Namespaces: []string{"apps"}, Policies: []string{"apps-read"}}}
+		}, field: "spec.roles[0].name"},
+		"secret store": {mutate: func(register *Register) {
+			register.Spec.SecretsBackend = "ExternalSecretsOperator"
+			register.Spec.SecretStore = &SecretStoreSpec{Kind: "ClusterSecretStore"}
+		}},
+		"secret store without operator": {mutate: func(register *Register) {
+			register.Spec.SecretStore = &SecretStoreSpec{Name: "vault"}
+		}, field: "spec.secretStore"},
+		"helm values of shared backend": {mutate: func(register *Register) {
+			register.Spec.SecretsBackend = "ExternalSecretsOperator"
+			register.Spec.HelmValues = &runtime.RawExtension{Raw: []byte(`{"replicaCount":2}`)}
+		}, field: "spec.helmValues"},
+		"secret provider class": {mutate: func(register *Register) {
+			register.Spec.SecretsBackend = "VaultCSIProvider"
+			register.Spec.SecretProviderClass = &SecretProviderClassSpec{Name: "vault"}
+		}},
+		"secret provider class without csi": {mutate: func(register *Register) {
+			register.Spec.SecretsBackend = "VaultAgentInjector"
+			register.Spec.SecretProviderClass = &SecretProviderClassSpec{Name: "vault"}
+		}, field: "spec.secretProviderClass"},
+		"operator reviewer": {mutate: func(register *Register) {
+			register.Spec.TokenReviewer = &TokenReviewerSpec{ServiceAccount: OperatorServiceAccount}
+		}, field: "spec.tokenReviewer.serviceAccount"},
+		"short lived reviewer token": {mutate: func(register *Register) {
+			register.Spec.TokenReviewer = &TokenReviewerSpec{TokenExpiration: &metav1.Duration{Duration: time.Hour},
+				RenewBefore: &metav1.Duration{Duration: 10 * time.Minute}}
+		}},
+		"reviewer token expiration too short": {mutate: func(register *Register) {
+			register.Spec.TokenReviewer = &TokenReviewerSpec{TokenExpiration: &metav1.Duration{Duration: time.Minute}}
+		}, field: "spec.tokenReviewer.tokenExpiration"},
+		"reviewer token renewed after expiry": {mutate: func(register *Register) {
+			register.Spec.TokenReviewer = &TokenReviewerSpec{TokenExpiration: &metav1.Duration{Duration: time.Hour},
+				RenewBefore: &metav1.Duration{Duration: time.Hour}}
+		}, field: "spec.tokenReviewer.renewBefore"},
+		"helm values": {mutate: func(register *Register) {
+			register.Spec.HelmValues = &runtime.RawExtension{Raw: []byte(`{"replicaCount":2}`)}
+			register.Spec.HelmValuesFrom = []ValuesReference{{Kind: "ConfigMap", Name: "chart-values"}}
+			register.Spec.OverrideProtectedValues = []string{"env.VAULT_ADDR"}
+		}},
+		"helm values not an object": {mutate: func(register *Register) {
+			register.Spec.HelmValues = &runtime.RawExtension{Raw: []byte(`["replicaCount"]`)}
+		}, field: "spec.helmValues"},
+		"helm values from name": {mutate: func(register *Register) {
+			register.Spec.HelmValuesFrom = []ValuesReference{{Kind: "Secret", Name: "Chart_Values"}}
+		}, field: "spec.helmValuesFrom[0].name"},
+		"protected value path": {mutate: func(register *Register) {
+			register.Spec.OverrideProtectedValues = []string{"env."}
+		}, field: "spec.overrideProtectedValues[0]"},
+	}
+	for name, test := range tests {
+		register := validRegister()
+		test.mutate(register)
+		err := register.ValidateCreate()
+		if len(test.field) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.field) {
+			t.Errorf("%s: expected an error on %s, got %v", name, test.field, err)
+		}
+	}
+}
+
+func TestRegisterValidateUpdate(t *testing.T) {
+	old := validRegister()
+	register := validRegister()
+	register.Spec.VaultNamespace = "team-a"
+	if err := register.ValidateUpdate(old); err == nil || !strings.Contains(err.Error(), "spec.vaultNamespace") {
+		t.Fatalf("expected the vault namespace to be immutable, got %v", err)
+	}
+	// the auth mount is moved to the new vault by the controller
+	register = validRegister()
+	register.Spec.VaultAddr = "https://vault-2.example.com:8200"
+	if err := register.ValidateUpdate(old); err != nil {
+		t.Fatalf("expected the vault address to be changeable, got %v", err)
+	}
+
+	// registers which are already invalid can still have their finalizer removed
+	old.Spec.VaultPolicy = nil
+	register = old.DeepCopy()
+	register.Finalizers = nil
+	if err := register.ValidateUpdate(old); err != nil {
+		t.Fatalf("metadata update rejected: %v", err)
+	}
+	register.Spec.VaultNamespace = "team-a"
+	now := metav1.NewTime(time.Now())
+	register.DeletionTimestamp = &now
+	if err := register.ValidateUpdate(old); err != nil {
+		t.Fatalf("update of a deleted Register rejected: %v", err)
+	}
+}
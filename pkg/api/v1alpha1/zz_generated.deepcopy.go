@@ -21,16 +21,87 @@ limitations under the License.
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppliedSpec) DeepCopyInto(out *AppliedSpec) {
+	*out = *in
+	if in.ReviewerTokenExpiry != nil {
+		in, out := &in.ReviewerTokenExpiry, &out.ReviewerTokenExpiry
+		*out = (*in).DeepCopy()
+	}
+	if in.BackendObjects != nil {
+		in, out := &in.BackendObjects, &out.BackendObjects
+		*out = make([]BackendObject, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppliedSpec.
+func (in *AppliedSpec) DeepCopy() *AppliedSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AppliedSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackendObject) DeepCopyInto(out *BackendObject) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackendObject.
+func (in *BackendObject) DeepCopy() *BackendObject {
+	if in == nil {
+		return nil
+	}
+	out := new(BackendObject)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ChartSpec) DeepCopyInto(out *ChartSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ChartSpec.
+func (in *ChartSpec) DeepCopy() *ChartSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ChartSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Condition) DeepCopyInto(out *Condition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Condition.
+func (in *Condition) DeepCopy() *Condition {
+	if in == nil {
+		return nil
+	}
+	out := new(Condition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Register) DeepCopyInto(out *Register) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Register.
@@ -96,6 +167,68 @@ func (in *RegisterSpec) DeepCopyInto(out *RegisterSpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Role != nil {
+		in, out := &in.Role, &out.Role
+		*out = new(RoleSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]VaultRoleSpec, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DriftCheckInterval != nil {
+		in, out := &in.DriftCheckInterval, &out.DriftCheckInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.VaultAuth != nil {
+		in, out := &in.VaultAuth, &out.VaultAuth
+		*out = new(VaultAuthSpec)
+		**out = **in
+	}
+	if in.VaultTLS != nil {
+		in, out := &in.VaultTLS, &out.VaultTLS
+		*out = new(VaultTLSSpec)
+		**out = **in
+	}
+	if in.TokenReviewer != nil {
+		in, out := &in.TokenReviewer, &out.TokenReviewer
+		*out = new(TokenReviewerSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Chart != nil {
+		in, out := &in.Chart, &out.Chart
+		*out = new(ChartSpec)
+		**out = **in
+	}
+	if in.SecretStore != nil {
+		in, out := &in.SecretStore, &out.SecretStore
+		*out = new(SecretStoreSpec)
+		**out = **in
+	}
+	if in.SecretProviderClass != nil {
+		in, out := &in.SecretProviderClass, &out.SecretProviderClass
+		*out = new(SecretProviderClassSpec)
+		**out = **in
+	}
+	if in.HelmValues != nil {
+		in, out := &in.HelmValues, &out.HelmValues
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HelmValuesFrom != nil {
+		in, out := &in.HelmValuesFrom, &out.HelmValuesFrom
+		*out = make([]ValuesReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.OverrideProtectedValues != nil {
+		in, out := &in.OverrideProtectedValues, &out.OverrideProtectedValues
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegisterSpec.
@@ -111,6 +244,31 @@ func (in *RegisterSpec) DeepCopy() *RegisterSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegisterStatus) DeepCopyInto(out *RegisterStatus) {
 	*out = *in
+	if in.Roles != nil {
+		in, out := &in.Roles, &out.Roles
+		*out = make([]RoleStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Applied != nil {
+		in, out := &in.Applied, &out.Applied
+		*out = new(AppliedSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastDriftCheck != nil {
+		in, out := &in.LastDriftCheck, &out.LastDriftCheck
+		*out = (*in).DeepCopy()
+	}
+	if in.NextRetry != nil {
+		in, out := &in.NextRetry, &out.NextRetry
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegisterStatus.
@@ -122,3 +280,169 @@ func (in *RegisterStatus) DeepCopy() *RegisterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoleSpec) DeepCopyInto(out *RoleSpec) {
+	*out = *in
+	if in.TokenBoundCIDRs != nil {
+		in, out := &in.TokenBoundCIDRs, &out.TokenBoundCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoleSpec.
+func (in *RoleSpec) DeepCopy() *RoleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RoleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoleStatus) DeepCopyInto(out *RoleStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoleStatus.
+func (in *RoleStatus) DeepCopy() *RoleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RoleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretProviderClassSpec) DeepCopyInto(out *SecretProviderClassSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretProviderClassSpec.
+func (in *SecretProviderClassSpec) DeepCopy() *SecretProviderClassSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretProviderClassSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretStoreSpec) DeepCopyInto(out *SecretStoreSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretStoreSpec.
+func (in *SecretStoreSpec) DeepCopy() *SecretStoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretStoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenReviewerSpec) DeepCopyInto(out *TokenReviewerSpec) {
+	*out = *in
+	if in.TokenExpiration != nil {
+		in, out := &in.TokenExpiration, &out.TokenExpiration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RenewBefore != nil {
+		in, out := &in.RenewBefore, &out.RenewBefore
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenReviewerSpec.
+func (in *TokenReviewerSpec) DeepCopy() *TokenReviewerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenReviewerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ValuesReference) DeepCopyInto(out *ValuesReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ValuesReference.
+func (in *ValuesReference) DeepCopy() *ValuesReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ValuesReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultAuthSpec) DeepCopyInto(out *VaultAuthSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultAuthSpec.
+func (in *VaultAuthSpec) DeepCopy() *VaultAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultRoleSpec) DeepCopyInto(out *VaultRoleSpec) {
+	*out = *in
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.RoleSpec.DeepCopyInto(&out.RoleSpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultRoleSpec.
+func (in *VaultRoleSpec) DeepCopy() *VaultRoleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultRoleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultTLSSpec) DeepCopyInto(out *VaultTLSSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultTLSSpec.
+func (in *VaultTLSSpec) DeepCopy() *VaultTLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultTLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,146 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	DefaultAppRoleSecret    = "vault-approle"
+	DefaultKubernetesMount  = "kubernetes"
+	DefaultAppRoleMount     = "approle"
+	tokenKey                = "token"
+	secretIDKey             = "secret_id"
+	legacyTokenAnnotation   = "token"
+	serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	// registerSecretLabel marks the secrets of the operator namespace a Register may reference
+	registerSecretLabel = "vault.cattle.io/register-secret"
+)
+
+// ServiceAccountTokenPath is the JWT used by the operator for kubernetes auth against vault
+var ServiceAccountTokenPath = serviceAccountTokenFile
+
+// resolveAuthenticator builds the authenticator the operator uses to login to vault.
+// It is resolved on each reconcile so rotated secrets are always picked up.
+func (r *RegisterReconciler) resolveAuthenticator(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (auth vault.Authenticator, err error) {
+	authSpec := vaultv1alpha1.VaultAuthSpec{}
+	if registerRequest.Spec.VaultAuth != nil {
+		authSpec = *registerRequest.Spec.VaultAuth
+	}
+
+	switch authSpec.Method {
+	case "", vault.AuthMethodToken:
+		secret, err := r.operatorSecret(ctx, authSpec.SecretName, DefaultSecret)
+		if err != nil {
+			return auth, err
+		}
+		token, err := secretValue(secret, tokenKey)
+		if err != nil {
+			return auth, err
+		}
+		auth = &vault.TokenAuth{Token: token}
+	case vault.AuthMethodKubernetes:
+		if len(authSpec.Role) == 0 {
+			return auth, permanentf("role is required for kubernetes vault auth")
+		}
+		jwt, err := ioutil.ReadFile(ServiceAccountTokenPath)
+		if err != nil {
+			return auth, err
+		}
+		auth = &vault.KubernetesAuth{
+			Mount: defaultString(authSpec.Mount, DefaultKubernetesMount),
+			Role:  authSpec.Role,
+			JWT:   strings.TrimSpace(string(jwt)),
+		}
+	case vault.AuthMethodAppRole:
+		if len(authSpec.RoleID) == 0 {
+			return auth, permanentf("roleID is required for approle vault auth")
+		}
+		secret, err := r.operatorSecret(ctx, authSpec.SecretName, DefaultAppRoleSecret)
+		if err != nil {
+			return auth, err
+		}
+		secretID, err := secretValue(secret, secretIDKey)
+		if err != nil {
+			return auth, err
+		}
+		auth = &vault.AppRoleAuth{
+			Mount:    defaultString(authSpec.Mount, DefaultAppRoleMount),
+			RoleID:   authSpec.RoleID,
+			SecretID: secretID,
+		}
+	default:
+		return auth, permanentf("unsupported vault auth method %s", authSpec.Method)
+	}
+
+	return auth, nil
+}
+
+// operatorSecret reads a secret of the operator namespace referenced by a Register. Secrets
+// other than defaultName, which the operator sets up itself, must be labelled
+// vault.cattle.io/register-secret=true, so a Register cannot have any secret of the operator
+// namespace sent to a server it chooses. A missing label is retried as adding it does not
+// change the spec
+func (r *RegisterReconciler) operatorSecret(ctx context.Context, name string,
+	defaultName string) (secret *v1.Secret, err error) {
+	name = defaultString(name, defaultName)
+	secret = &v1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(), Name: name}, secret)
+	if err != nil {
+		return secret, err
+	}
+	if name != defaultName && secret.Labels[registerSecretLabel] != "true" {
+		return secret, fmt.Errorf("secret %s in namespace %s is not labelled %s=true", name,
+			operatorNamespace(), registerSecretLabel)
+	}
+	return secret, nil
+}
+
+func secretValue(secret *v1.Secret, key string) (value string, err error) {
+	valueByte, ok := secret.Data[key]
+	if !ok {
+		return value, fmt.Errorf("%s key not found in secret %s in namespace %s", key, secret.Name,
+			secret.Namespace)
+	}
+	return strings.TrimSpace(string(valueByte)), nil
+}
+
+func operatorNamespace() (namespace string) {
+	namespace, ok := os.LookupEnv("NAMESPACE")
+	if !ok || len(namespace) == 0 {
+		namespace = DefaultNamespace
+	}
+	return namespace
+}
+
+func defaultString(value string, defaultValue string) string {
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,173 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// loginVault fakes the kubernetes and approle login endpoints and answers sys/auth listing
+// with the token it handed out, or the static token
+func loginVault(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body := map[string]string{}
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		switch req.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			if body["role"] != "vault-glue-operator" || body["jwt"] != "operator-jwt" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.kubernetes"}}`))
+		case "/v1/auth/approle/login":
+			if body["role_id"] != "role-id" || body["secret_id"] != "secret-id" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["invalid secret id"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.approle"}}`))
+		case "/v1/sys/auth":
+			switch req.Header.Get("X-Vault-Token") {
+			case "s.static", "s.kubernetes", "s.approle":
+				_, _ = w.Write([]byte(`{"data":{"token/":{"type":"token"}}}`))
+			default:
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			}
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestResolveAuthenticator(t *testing.T) {
+	server := loginVault(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "vault-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ServiceAccountTokenPath = filepath.Join(dir, "token")
+	defer func() { ServiceAccountTokenPath = serviceAccountTokenFile }()
+	if err := ioutil.WriteFile(ServiceAccountTokenPath, []byte("operator-jwt\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := func(name string, key string, value string, labels map[string]string) *v1.Secret {
+		return &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: operatorNamespace(), Labels: labels},
+			Data:       map[string][]byte{key: []byte(value)},
+		}
+	}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		secret(DefaultSecret, tokenKey, "s.static", nil),
+		secret(DefaultAppRoleSecret, secretIDKey, "secret-id", nil),
+		secret("team-approle", secretIDKey, "secret-id", map[string]string{registerSecretLabel: "true"}),
+		secret("unrelated", tokenKey, "s.static", nil),
+	), Scheme: scheme.Scheme}
+
+	tests := []struct {
+		name          string
+		auth          *vaultv1alpha1.VaultAuthSpec
+		wantSecretErr bool
+		wantLoginErr  bool
+	}{
+		{
+			name: "default token secret",
+		},
+		{
+			name: "kubernetes",
+			auth: &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodKubernetes, Role: "vault-glue-operator"},
+		},
+		{
+			name:         "kubernetes role refused by vault",
+			auth:         &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodKubernetes, Role: "other"},
+			wantLoginErr: true,
+		},
+		{
+			name: "approle with default secret",
+			auth: &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodAppRole, RoleID: "role-id"},
+		},
+		{
+			name: "approle with labelled secret",
+			auth: &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodAppRole, RoleID: "role-id",
+				SecretName: "team-approle"},
+		},
+		{
+			name:         "approle role refused by vault",
+			auth:         &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodAppRole, RoleID: "other"},
+			wantLoginErr: true,
+		},
+		{
+			name:          "unlabelled token secret",
+			auth:          &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodToken, SecretName: "unrelated"},
+			wantSecretErr: true,
+		},
+		{
+			name: "unlabelled approle secret",
+			auth: &vaultv1alpha1.VaultAuthSpec{Method: vault.AuthMethodAppRole, RoleID: "role-id",
+				SecretName: "unrelated"},
+			wantSecretErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registerRequest := &vaultv1alpha1.Register{Spec: vaultv1alpha1.RegisterSpec{VaultAuth: test.auth}}
+			auth, err := r.resolveAuthenticator(context.Background(), registerRequest)
+			if test.wantSecretErr {
+				// retried, labelling the secret does not change the spec
+				if err == nil || isPermanent(err) {
+					t.Fatalf("expected a transient error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// any request logs in first and then uses the token it got
+			v := &vault.VaultRegister{VaultAddress: server.URL, Auth: auth}
+			_, err = v.DetectDrift()
+			if test.wantLoginErr && err == nil {
+				t.Fatalf("expected the login to fail")
+			}
+			if !test.wantLoginErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
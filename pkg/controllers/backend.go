@@ -0,0 +1,205 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	defaultSecretStorePath    = "secret"
+	defaultSecretStoreVersion = "v2"
+)
+
+// secretsBackend resolves the backend named in the spec or status, an empty name
+// being kubernetes-external-secrets
+func secretsBackend(name string) (backend helm.Backend, err error) {
+	backend, err = helm.GetBackend(name)
+	if err != nil {
+		return backend, permanentf("%v", err)
+	}
+	return backend, nil
+}
+
+// sharedBackendSpec rejects the chart settings of a Register using a shared backend, as the
+// release they would change is the same for every Register
+func sharedBackendSpec(registerRequest *vaultv1alpha1.Register, backend helm.Backend) (err error) {
+	spec := registerRequest.Spec
+	if spec.Chart != nil || spec.HelmValues != nil || len(spec.HelmValuesFrom) != 0 ||
+		len(spec.OverrideProtectedValues) != 0 {
+		return permanentf("chart, helmValues, helmValuesFrom and overrideProtectedValues cannot be set "+
+			"for the shared %s backend", backend.Name())
+	}
+	return nil
+}
+
+// sharedChartInUse is true while another Register has the chart of the shared backend installed
+func (r *RegisterReconciler) sharedChartInUse(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	backend helm.Backend) (inUse bool, err error) {
+	registers := &vaultv1alpha1.RegisterList{}
+	if err = r.List(ctx, registers); err != nil {
+		return false, err
+	}
+	for _, other := range registers.Items {
+		if other.UID == registerRequest.UID || other.Status.HelmStatus != "Installed" {
+			continue
+		}
+		if applied := other.Status.Applied; applied != nil && applied.SecretsBackend == backend.Name() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// secretStoreOptions applies the defaults of the store generated for the
+// external secrets operator
+func secretStoreOptions(registerRequest *vaultv1alpha1.Register) (options helm.SecretStoreOptions) {
+	options = helm.SecretStoreOptions{
+		Kind:    helm.SecretStoreKind,
+		Name:    registerRequest.Name,
+		Path:    defaultSecretStorePath,
+		Version: defaultSecretStoreVersion,
+	}
+	store := registerRequest.Spec.SecretStore
+	if store == nil {
+		return options
+	}
+	if len(store.Kind) != 0 {
+		options.Kind = store.Kind
+	}
+	if len(store.Name) != 0 {
+		options.Name = store.Name
+	}
+	if len(store.Path) != 0 {
+		options.Path = store.Path
+	}
+	if len(store.Version) != 0 {
+		options.Version = store.Version
+	}
+	return options
+}
+
+// applyBackendObjects creates or updates the objects of the backend and removes the ones
+// applied previously that are no longer wanted
+func (r *RegisterReconciler) applyBackendObjects(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	applied *vaultv1alpha1.AppliedSpec, objects []*unstructured.Unstructured) (err error) {
+	refs := []vaultv1alpha1.BackendObject{}
+	for _, desired := range objects {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(desired.GroupVersionKind())
+		obj.SetNamespace(desired.GetNamespace())
+		obj.SetName(desired.GetName())
+		result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, func() error {
+			labels := obj.GetLabels()
+			if len(obj.GetResourceVersion()) != 0 && labels[registerUIDLabel] != string(registerRequest.UID) {
+				return permanentf("%s %s exists and is not managed by this Register",
+					obj.GetKind(), obj.GetName())
+			}
+			if labels == nil {
+				labels = map[string]string{}
+			}
+			labels[registerUIDLabel] = string(registerRequest.UID)
+			obj.SetLabels(labels)
+			obj.Object["spec"] = desired.Object["spec"]
+			return nil
+		})
+		if err != nil {
+			// objects applied so far are tracked for their cleanup
+			for _, ref := range refs {
+				if !containsBackendObject(applied.BackendObjects, ref) {
+					applied.BackendObjects = append(applied.BackendObjects, ref)
+				}
+			}
+			return err
+		}
+		ref := backendObjectRef(obj)
+		if result != controllerutil.OperationResultNone {
+			r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "BackendObjectApplied",
+				"%s %s %s", ref.Kind, objectName(ref), result)
+		}
+		refs = append(refs, ref)
+	}
+
+	var stale []vaultv1alpha1.BackendObject
+	for _, ref := range applied.BackendObjects {
+		if !containsBackendObject(refs, ref) {
+			stale = append(stale, ref)
+		}
+	}
+	// objects not yet deleted are still tracked so they are retried
+	stale, err = r.deleteBackendObjects(ctx, registerRequest, stale)
+	applied.BackendObjects = append(refs, stale...)
+	return err
+}
+
+// deleteBackendObjects removes the objects created for this Register and returns the ones
+// left behind. Objects whose CRD is gone along with the chart are already deleted
+func (r *RegisterReconciler) deleteBackendObjects(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	refs []vaultv1alpha1.BackendObject) (remaining []vaultv1alpha1.BackendObject, err error) {
+	for _, ref := range refs {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(ref.APIVersion)
+		obj.SetKind(ref.Kind)
+		deleteErr := r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, obj)
+		if deleteErr == nil && obj.GetLabels()[registerUIDLabel] == string(registerRequest.UID) {
+			if deleteErr = r.Delete(ctx, obj); deleteErr == nil {
+				r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "BackendObjectDeleted",
+					"%s %s deleted", ref.Kind, objectName(ref))
+			}
+		}
+		if deleteErr != nil && !errors.IsNotFound(deleteErr) && !meta.IsNoMatchError(deleteErr) {
+			remaining = append(remaining, ref)
+			err = fmt.Errorf("unable to delete %s %s: %v", ref.Kind, objectName(ref), deleteErr)
+		}
+	}
+	return remaining, err
+}
+
+func backendObjectRef(obj *unstructured.Unstructured) vaultv1alpha1.BackendObject {
+	return vaultv1alpha1.BackendObject{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+func containsBackendObject(refs []vaultv1alpha1.BackendObject, ref vaultv1alpha1.BackendObject) bool {
+	for _, item := range refs {
+		if item == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func objectName(ref vaultv1alpha1.BackendObject) string {
+	if len(ref.Namespace) == 0 {
+		return ref.Name
+	}
+	return ref.Namespace + "/" + ref.Name
+}
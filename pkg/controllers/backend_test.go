@@ -0,0 +1,164 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApplyBackendObjects(t *testing.T) {
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", UID: "uid-1"},
+		Spec: vaultv1alpha1.RegisterSpec{Namespace: "apps", ServiceAccount: "external-secrets",
+			VaultAddr: "https://vault:8200", RoleName: "demo", SecretsBackend: helm.BackendExternalSecretsOperator},
+	}
+	r := &RegisterReconciler{
+		Client:   fake.NewFakeClientWithScheme(scheme.Scheme),
+		Recorder: record.NewFakeRecorder(10),
+	}
+	applied := &vaultv1alpha1.AppliedSpec{}
+
+	helmWrapper, err := prepareHelmWrapper(registerRequest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := helmWrapper.Objects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.applyBackendObjects(ctx, registerRequest, applied, objects); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := &unstructured.Unstructured{}
+	store.SetAPIVersion(helm.ESOAPIVersion)
+	store.SetKind(helm.SecretStoreKind)
+	if err = r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "demo"}, store); err != nil {
+		t.Fatalf("store not created: %v", err)
+	}
+	if path, _, _ := unstructured.NestedString(store.Object, "spec", "provider", "vault", "path"); path != "secret" {
+		t.Fatalf("store defaults not applied: %v", store.Object)
+	}
+	if len(applied.BackendObjects) != 1 {
+		t.Fatalf("store not recorded: %v", applied.BackendObjects)
+	}
+
+	// renaming the store removes the previous one
+	registerRequest.Spec.SecretStore = &vaultv1alpha1.SecretStoreSpec{Name: "vault"}
+	helmWrapper, _ = prepareHelmWrapper(registerRequest, false)
+	objects, _ = helmWrapper.Objects()
+	if err = r.applyBackendObjects(ctx, registerRequest, applied, objects); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied.BackendObjects) != 1 || applied.BackendObjects[0].Name != "vault" {
+		t.Fatalf("unexpected objects recorded: %v", applied.BackendObjects)
+	}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "demo"}, store)
+	if err == nil {
+		t.Fatal("previous store not deleted")
+	}
+
+	// a store created by someone else is left alone
+	other := registerRequest.DeepCopy()
+	other.UID = "uid-2"
+	if err = r.applyBackendObjects(ctx, other, &vaultv1alpha1.AppliedSpec{}, objects); !isPermanent(err) {
+		t.Fatalf("expected a store owned by another Register to be rejected, got %v", err)
+	}
+
+	remaining, err := r.deleteBackendObjects(ctx, registerRequest, applied.BackendObjects)
+	if err != nil || len(remaining) != 0 {
+		t.Fatalf("unexpected cleanup result: %v %v", remaining, err)
+	}
+	if err = r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "vault"}, store); err == nil {
+		t.Fatal("store not deleted")
+	}
+}
+
+func TestReconcileChartSkippedWithObjects(t *testing.T) {
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", UID: "uid-1"},
+		Spec: vaultv1alpha1.RegisterSpec{Namespace: "apps", ServiceAccount: "default",
+			VaultAddr: "https://vault:8200", RoleName: "demo", SecretsBackend: helm.BackendVaultCSIProvider,
+			SkipExternalSecretInstall: true},
+	}
+	registerStatus := &vaultv1alpha1.RegisterStatus{VaultAuthMount: "k8s-demo"}
+	r := &RegisterReconciler{
+		Client:   fake.NewFakeClientWithScheme(scheme.Scheme),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	condition, err := r.reconcileChart(ctx, registerRequest, registerStatus)
+	if err != nil || condition.Reason != "Skipped" {
+		t.Fatalf("unexpected result: %+v %v", condition, err)
+	}
+	class := &unstructured.Unstructured{}
+	class.SetAPIVersion(helm.SecretProviderClassAPIVersion)
+	class.SetKind(helm.SecretProviderClassKind)
+	if err = r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "demo"}, class); err != nil {
+		t.Fatalf("class not created for a provider installed separately: %v", err)
+	}
+	mount, _, _ := unstructured.NestedString(class.Object, "spec", "parameters", "vaultKubernetesMountPath")
+	if mount != "k8s-demo" || len(registerStatus.Applied.BackendObjects) != 1 {
+		t.Fatalf("unexpected class %v recorded as %v", class.Object, registerStatus.Applied.BackendObjects)
+	}
+}
+
+func TestSharedBackendChart(t *testing.T) {
+	ctx := context.Background()
+	registerScheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(registerScheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := vaultv1alpha1.AddToScheme(registerScheme); err != nil {
+		t.Fatal(err)
+	}
+	first := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default", UID: "uid-1"},
+		Spec:       vaultv1alpha1.RegisterSpec{SecretsBackend: helm.BackendExternalSecretsOperator},
+		Status: vaultv1alpha1.RegisterStatus{HelmStatus: "Installed",
+			Applied: &vaultv1alpha1.AppliedSpec{SecretsBackend: helm.BackendExternalSecretsOperator}},
+	}
+	second := first.DeepCopy()
+	second.Name = "second"
+	second.UID = "uid-2"
+	r := &RegisterReconciler{
+		Client:   fake.NewFakeClientWithScheme(registerScheme, first, second),
+		Recorder: record.NewFakeRecorder(10),
+	}
+
+	// the release is left to the other Register, no helm client is needed
+	if err := r.uninstallChart(ctx, first, "apps", helm.BackendExternalSecretsOperator); err != nil {
+		t.Fatal(err)
+	}
+
+	second.Spec.HelmValues = &runtime.RawExtension{Raw: []byte(`{"replicaCount":2}`)}
+	if _, err := r.reconcileChart(ctx, second, second.Status.DeepCopy()); !isPermanent(err) {
+		t.Fatalf("expected values of a shared chart to be refused, got %v", err)
+	}
+}
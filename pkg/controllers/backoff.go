@@ -0,0 +1,154 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// BaseRetryDelay is the delay after the first failed attempt
+	BaseRetryDelay = 5 * time.Second
+	// MaxRetryDelay caps the exponential backoff between attempts
+	MaxRetryDelay = 5 * time.Minute
+	// retryJitter spreads retries of many Registers failing on the same vault
+	retryJitter = 0.2
+)
+
+// permanentError is an error retrying cannot fix, usually an invalid spec
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks err as not worth retrying until the spec changes
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// permanentf formats a permanent error
+func permanentf(format string, args ...interface{}) error {
+	return permanent(fmt.Errorf(format, args...))
+}
+
+// isPermanent classifies an error. Vault rejecting a request as bad or forbidden, mount
+// collisions and invalid objects are permanent. Network errors, 5xx including a sealed vault,
+// throttling and anything unknown are transient
+func isPermanent(err error) bool {
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+		return true
+	}
+	if isMountNotOwned(err) {
+		// a different mountPath is needed
+		return true
+	}
+	var responseErr *api.ResponseError
+	if errors.As(err, &responseErr) {
+		switch responseErr.StatusCode {
+		case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusPreconditionFailed:
+			// vault uses 412 while a performance standby catches up
+			return false
+		}
+		return responseErr.StatusCode >= 400 && responseErr.StatusCode < 500
+	}
+	return apierrors.IsInvalid(err) || apierrors.IsBadRequest(err)
+}
+
+// retryDelay is the exponential backoff with jitter for the given attempt, starting at 1
+func retryDelay(attempts int32) time.Duration {
+	delay := BaseRetryDelay
+	for i := int32(1); i < attempts && delay < MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxRetryDelay {
+		delay = MaxRetryDelay
+	}
+	return wait.Jitter(delay, retryJitter)
+}
+
+// recordAttempt updates the retry bookkeeping in the status and returns when to
+// requeue. Zero means no retry is scheduled
+func recordAttempt(registerRequest *vaultv1alpha1.Register, registerStatus *vaultv1alpha1.RegisterStatus,
+	err error) (requeueAfter time.Duration) {
+	if err == nil {
+		registerStatus.Attempts = 0
+		registerStatus.NextRetry = nil
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionStalled, metav1.ConditionFalse,
+			"Reconciled", "")
+		return 0
+	}
+
+	registerStatus.Attempts++
+	if isPermanent(err) {
+		registerStatus.NextRetry = nil
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionStalled, metav1.ConditionTrue,
+			"PermanentError", err.Error())
+		return 0
+	}
+
+	requeueAfter = scheduleRetry(registerStatus)
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionStalled, metav1.ConditionFalse,
+		"Retrying", err.Error())
+	return requeueAfter
+}
+
+// recordReadyAttempt updates the retry bookkeeping for the work done on a ready Register. Its
+// failures are always retried, a ready Register is never stalled
+func recordReadyAttempt(registerStatus *vaultv1alpha1.RegisterStatus, err error) (requeueAfter time.Duration) {
+	if err == nil {
+		registerStatus.Attempts = 0
+		registerStatus.NextRetry = nil
+		return 0
+	}
+	registerStatus.Attempts++
+	return scheduleRetry(registerStatus)
+}
+
+// scheduleRetry records when the last failed attempt is retried and returns the delay
+func scheduleRetry(registerStatus *vaultv1alpha1.RegisterStatus) (requeueAfter time.Duration) {
+	requeueAfter = retryDelay(registerStatus.Attempts)
+	nextRetry := metav1.NewTime(time.Now().Add(requeueAfter))
+	registerStatus.NextRetry = &nextRetry
+	return requeueAfter
+}
+
+// isStalled is true when the current generation failed permanently
+func isStalled(registerRequest *vaultv1alpha1.Register) bool {
+	stalled := registerRequest.Status.GetCondition(vaultv1alpha1.ConditionStalled)
+	return stalled != nil && stalled.Status == metav1.ConditionTrue &&
+		stalled.ObservedGeneration == registerRequest.Generation
+}
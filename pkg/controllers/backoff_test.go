@@ -0,0 +1,85 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "invalid spec", err: permanentf("duplicate vault role %s", "demo"), want: true},
+		{name: "wrapped invalid spec", err: fmt.Errorf("reconcile: %w", permanentf("invalid")), want: true},
+		{name: "permission denied", err: &api.ResponseError{StatusCode: 403}, want: true},
+		{name: "bad request", err: &api.ResponseError{StatusCode: 400}, want: true},
+		{name: "sealed vault", err: &api.ResponseError{StatusCode: 503}},
+		{name: "internal error", err: &api.ResponseError{StatusCode: 500}},
+		{name: "throttled", err: &api.ResponseError{StatusCode: 429}},
+		{name: "network", err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isPermanent(test.err); got != test.want {
+				t.Fatalf("isPermanent() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	maxJittered := time.Duration(float64(MaxRetryDelay) * (1 + retryJitter))
+	previous := time.Duration(0)
+	for attempts := int32(1); attempts <= 20; attempts++ {
+		delay := retryDelay(attempts)
+		if delay < BaseRetryDelay || delay > maxJittered {
+			t.Fatalf("attempt %d: delay %v out of bounds", attempts, delay)
+		}
+		if attempts <= 4 && delay <= previous {
+			t.Fatalf("attempt %d: delay %v did not grow from %v", attempts, delay, previous)
+		}
+		previous = delay
+	}
+}
+
+func TestRecordReadyAttempt(t *testing.T) {
+	registerStatus := &vaultv1alpha1.RegisterStatus{}
+	for attempt := int32(1); attempt <= 2; attempt++ {
+		requeueAfter := recordReadyAttempt(registerStatus, permanentf("refused"))
+		if registerStatus.Attempts != attempt || registerStatus.NextRetry == nil || requeueAfter < BaseRetryDelay {
+			t.Fatalf("attempt %d: unexpected retry %+v after %v", attempt, registerStatus, requeueAfter)
+		}
+	}
+	// a ready Register is never stalled, only the backoff is kept
+	if registerStatus.GetCondition(vaultv1alpha1.ConditionStalled) != nil {
+		t.Fatalf("ready Register stalled: %v", registerStatus.Conditions)
+	}
+	if requeueAfter := recordReadyAttempt(registerStatus, nil); requeueAfter != 0 ||
+		registerStatus.Attempts != 0 || registerStatus.NextRetry != nil {
+		t.Fatalf("backoff not reset after success: %+v", registerStatus)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+)
+
+const (
+	usernameKey = "username"
+	passwordKey = "password"
+	keyringKey  = "keyring"
+)
+
+// chartSource resolves where the chart is loaded from. A chart set on the Register is only
+// used from an allowed repository, as the chart is installed with the operator's permissions.
+// The operator's default chart replaces the kubernetes-external-secrets chart only
+func (r *RegisterReconciler) chartSource(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (source helm.ChartSource, err error) {
+	var spec vaultv1alpha1.ChartSpec
+	if backend, _ := secretsBackend(registerRequest.Spec.SecretsBackend); backend != nil &&
+		backend.Name() == helm.BackendKubernetesExternalSecrets {
+		spec = r.DefaultChart
+	}
+	if registerRequest.Spec.Chart != nil {
+		spec = *registerRequest.Spec.Chart
+		if err = r.allowedChart(spec); err != nil {
+			return source, err
+		}
+	}
+
+	source = helm.ChartSource{
+		Path:       spec.Path,
+		Repository: spec.Repository,
+		Name:       spec.Name,
+		Version:    spec.Version,
+		Digest:     spec.Digest,
+		CacheDir:   r.ChartCacheDir,
+	}
+	if len(spec.CredentialsSecret) != 0 {
+		secret, err := r.operatorSecret(ctx, spec.CredentialsSecret, "")
+		if err != nil {
+			return source, err
+		}
+		source.Username = string(secret.Data[usernameKey])
+		source.Password = string(secret.Data[passwordKey])
+		source.CACert = secret.Data[caKey]
+	}
+	if len(spec.KeyringSecret) != 0 {
+		secret, err := r.operatorSecret(ctx, spec.KeyringSecret, "")
+		if err != nil {
+			return source, err
+		}
+		source.Keyring = secret.Data[keyringKey]
+		if len(source.Keyring) == 0 {
+			// retried, fixing the secret does not change the spec
+			return source, fmt.Errorf("%s key not found in secret %s", keyringKey, spec.KeyringSecret)
+		}
+	}
+	return source, nil
+}
+
+// allowedChart permits repositories under an --allowed-chart-repositories prefix, and
+// tarballs in the directory of the bundled chart
+func (r *RegisterReconciler) allowedChart(spec vaultv1alpha1.ChartSpec) error {
+	if len(spec.Repository) != 0 {
+		for _, prefix := range r.AllowedChartRepositories {
+			if allowedRepository(spec.Repository, prefix) {
+				return nil
+			}
+		}
+		return permanentf("chart repository %s is not allowed by the operator", spec.Repository)
+	}
+	if len(spec.Path) != 0 {
+		chartDir := helm.ChartDir()
+		if filepath.Dir(filepath.Clean(spec.Path)) != chartDir {
+			return permanentf("chart path %s is not in %s", spec.Path, chartDir)
+		}
+	}
+	return nil
+}
+
+// allowedRepository compares the scheme and host exactly and matches the path on a / boundary,
+// so https://charts.example.com/stable allows neither charts.example.com.evil.com nor /stable-evil
+func allowedRepository(repository string, prefix string) bool {
+	prefixURL, err := url.Parse(strings.TrimSpace(prefix))
+	if err != nil || len(prefixURL.Host) == 0 {
+		return false
+	}
+	repositoryURL, err := url.Parse(repository)
+	if err != nil || repositoryURL.User != nil {
+		return false
+	}
+	if !strings.EqualFold(repositoryURL.Scheme, prefixURL.Scheme) || !strings.EqualFold(repositoryURL.Host, prefixURL.Host) {
+		return false
+	}
+	allowedPath := strings.TrimSuffix(prefixURL.Path, "/")
+	repositoryPath := path.Clean("/" + repositoryURL.Path)
+	return allowedPath == "" || repositoryPath == allowedPath || strings.HasPrefix(repositoryPath, allowedPath+"/")
+}
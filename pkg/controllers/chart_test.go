@@ -0,0 +1,103 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestChartSource(t *testing.T) {
+	ctx := context.Background()
+	credentials := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "chart-repo", Namespace: operatorNamespace(),
+			Labels: map[string]string{registerSecretLabel: "true"}},
+		Data: map[string][]byte{usernameKey: []byte("reader"), passwordKey: []byte("secret")},
+	}
+	r := &RegisterReconciler{
+		Client:                   fake.NewFakeClientWithScheme(scheme.Scheme, credentials),
+		DefaultChart:             vaultv1alpha1.ChartSpec{Repository: "https://charts.example.com", Version: "6.2.0"},
+		AllowedChartRepositories: []string{"oci://registry.example.com/charts/"},
+		ChartCacheDir:            "/tmp/charts",
+	}
+	registerRequest := &vaultv1alpha1.Register{}
+
+	source, err := r.chartSource(ctx, registerRequest)
+	if err != nil || source.Repository != "https://charts.example.com" || source.Version != "6.2.0" {
+		t.Fatalf("operator chart not used: %+v %v", source, err)
+	}
+
+	registerRequest.Spec.Chart = &vaultv1alpha1.ChartSpec{
+		Repository:        "oci://registry.example.com/charts/kubernetes-external-secrets",
+		CredentialsSecret: "chart-repo",
+	}
+	source, err = r.chartSource(ctx, registerRequest)
+	if err != nil || source.Username != "reader" || source.Password != "secret" || source.CacheDir != "/tmp/charts" {
+		t.Fatalf("register chart not resolved: %+v %v", source, err)
+	}
+
+	// secrets of the operator namespace are only sent to a repository when labelled for it, and
+	// fixing a secret is retried
+	unlabelled := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-credentials", Namespace: operatorNamespace()},
+		Data:       map[string][]byte{usernameKey: []byte("admin"), passwordKey: []byte("secret")},
+	}
+	if err := r.Create(ctx, unlabelled); err != nil {
+		t.Fatal(err)
+	}
+	registerRequest.Spec.Chart.CredentialsSecret = unlabelled.Name
+	if source, err = r.chartSource(ctx, registerRequest); err == nil || isPermanent(err) {
+		t.Fatalf("expected unlabelled credentials to be retried, got %+v %v", source, err)
+	}
+	registerRequest.Spec.Chart.CredentialsSecret = ""
+	registerRequest.Spec.Chart.KeyringSecret = unlabelled.Name
+	if _, err = r.chartSource(ctx, registerRequest); err == nil || isPermanent(err) {
+		t.Fatalf("expected unlabelled keyring to be retried, got %v", err)
+	}
+	registerRequest.Spec.Chart.KeyringSecret = credentials.Name
+	if _, err = r.chartSource(ctx, registerRequest); err == nil || isPermanent(err) {
+		t.Fatalf("expected a missing keyring to be retried, got %v", err)
+	}
+
+	for _, repository := range []string{
+		"https://evil.example.com",
+		"oci://registry.example.com.evil.com/charts/kubernetes-external-secrets",
+		"oci://registry.example.com/charts-evil/kubernetes-external-secrets",
+		"oci://registry.example.com/charts/../private/kubernetes-external-secrets",
+		"oci://registry.example.com@evil.example.com/charts/kubernetes-external-secrets",
+		"https://registry.example.com/charts/kubernetes-external-secrets",
+	} {
+		registerRequest.Spec.Chart = &vaultv1alpha1.ChartSpec{Repository: repository}
+		if _, err = r.chartSource(ctx, registerRequest); !isPermanent(err) {
+			t.Fatalf("expected repository %s to be rejected, got %v", repository, err)
+		}
+	}
+	registerRequest.Spec.Chart = &vaultv1alpha1.ChartSpec{Path: "/etc/../data/other.tgz"}
+	if _, err = r.chartSource(ctx, registerRequest); err != nil {
+		t.Fatalf("tarball next to the bundled chart rejected: %v", err)
+	}
+	registerRequest.Spec.Chart = &vaultv1alpha1.ChartSpec{Path: "/var/run/other.tgz"}
+	if _, err = r.chartSource(ctx, registerRequest); !isPermanent(err) {
+		t.Fatalf("expected a tarball outside the chart directory to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+	"helm.sh/helm/v3/pkg/release"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultDriftCheckInterval is used when the Register does not set driftCheckInterval
+const DefaultDriftCheckInterval = 10 * time.Minute
+
+func driftCheckInterval(registerRequest *vaultv1alpha1.Register) time.Duration {
+	if registerRequest.Spec.DriftCheckInterval == nil {
+		return DefaultDriftCheckInterval
+	}
+	return registerRequest.Spec.DriftCheckInterval.Duration
+}
+
+// nextDriftCheck returns how long until the next drift check is due
+func nextDriftCheck(registerRequest *vaultv1alpha1.Register) (due time.Duration) {
+	lastCheck := registerRequest.Status.LastDriftCheck
+	if lastCheck == nil {
+		return 0
+	}
+	return driftCheckInterval(registerRequest) - time.Since(lastCheck.Time)
+}
+
+// checkDrift compares vault against the spec and repairs anything out of sync
+func (r *RegisterReconciler) checkDrift(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	now := metav1.Now()
+	registerStatus.LastDriftCheck = &now
+
+	v, err := r.prepareVaultRequest(ctx, registerRequest, registerStatus)
+	var drift []string
+	if err == nil {
+		drift, err = v.DetectDrift()
+	}
+	if err != nil {
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionDrifted, metav1.ConditionUnknown,
+			"CheckFailed", err.Error())
+		return err
+	}
+
+	if len(drift) == 0 && len(v.SAToken) != 0 {
+		// vault never returns the reviewer JWT, a token replaced by hand is only undone by
+		// writing it on every check
+		if err = v.WriteConfig(); err != nil {
+			setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionDrifted, metav1.ConditionUnknown,
+				"CheckFailed", err.Error())
+			return err
+		}
+		recordAuthConfig(registerRequest, registerStatus, v)
+	}
+	if len(drift) == 0 {
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionDrifted, metav1.ConditionFalse,
+			"InSync", "vault auth configuration matches the spec")
+		return nil
+	}
+
+	driftMessage := "drift detected in " + strings.Join(drift, ", ")
+	r.Log.Info("Repairing vault drift", "register", registerRequest.Name, "drift", drift)
+	_, err = v.RegisterCluster()
+	if err == nil {
+		recordAuthConfig(registerRequest, registerStatus, v)
+		err = r.syncRoles(v, registerRequest, registerStatus)
+	}
+
+	if err != nil {
+		driftMessage = driftMessage + ": " + err.Error()
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionDrifted, metav1.ConditionTrue,
+			"RepairFailed", driftMessage)
+		r.Recorder.Event(registerRequest, v1.EventTypeWarning, "DriftRepairFailed", driftMessage)
+		return err
+	}
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionDrifted, metav1.ConditionTrue,
+		"Repaired", driftMessage)
+	r.Recorder.Event(registerRequest, v1.EventTypeNormal, "DriftRepaired", driftMessage)
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	return nil
+}
+
+// checkChart installs the chart again when its release was removed or left failed behind our back
+func (r *RegisterReconciler) checkChart(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	if registerStatus.HelmStatus != "Installed" {
+		return nil
+	}
+	applied := appliedSpec(registerStatus)
+	backend, err := secretsBackend(applied.SecretsBackend)
+	if err != nil {
+		return err
+	}
+	helmWrapper := helm.Wrapper{Namespace: appliedNamespace(registerRequest), Backend: backend,
+		SharedNamespace: operatorNamespace()}
+	rel, err := helmWrapper.ReleaseStatus(r.Helm)
+	if err != nil {
+		return err
+	}
+	state := "missing"
+	if rel != nil && rel.Info != nil {
+		if rel.Info.Status == release.StatusDeployed {
+			return nil
+		}
+		state = rel.Info.Status.String()
+	}
+
+	r.Recorder.Eventf(registerRequest, v1.EventTypeWarning, "ChartDrifted", "release %s in namespace %s is %s",
+		backend.ReleaseName(), helmWrapper.ReleaseNamespace(), state)
+	// a changed checksum makes the chart step install the release again
+	applied.HelmValuesChecksum = ""
+	condition, err := r.reconcileChart(ctx, registerRequest, registerStatus)
+	if err != nil {
+		r.Recorder.Event(registerRequest, v1.EventTypeWarning, "ChartInstallFailed", err.Error())
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionExternalSecretsInstalled,
+			metav1.ConditionFalse, "ChartInstallFailed", err.Error())
+		return err
+	}
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionExternalSecretsInstalled,
+		metav1.ConditionTrue, condition.Reason, condition.Message)
+	return nil
+}
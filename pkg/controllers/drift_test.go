@@ -0,0 +1,119 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckChart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	chrt := &chart.Chart{Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2,
+		Name: helm.DefaultChartName, Version: helm.ChartVersion}}
+	if _, err = chartutil.Save(chrt, dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CHART_PATH", dir)
+	defer os.Unsetenv("CHART_PATH")
+
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "register-uid"},
+		Spec: vaultv1alpha1.RegisterSpec{
+			VaultAddr:      "https://vault:8200",
+			ServiceAccount: "vault-auth",
+			Namespace:      "apps",
+			VaultPolicy:    []string{"read"},
+		},
+	}
+	registerRequest.SetDefaults()
+	recorder := record.NewFakeRecorder(100)
+	kubeClient := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Scheme: scheme.Scheme,
+		Recorder: recorder, Helm: helm.NewFakeClient(kubeClient)}
+	registerStatus := &vaultv1alpha1.RegisterStatus{VaultAuthMount: "k8s-demo"}
+	if _, err = r.reconcileChart(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	registerStatus.HelmStatus = "Installed"
+	drainEvents(recorder)
+
+	// a deployed release is left alone
+	if err = r.checkChart(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Fatalf("deployed release touched: %v", events)
+	}
+
+	// a release removed behind our back is installed again
+	if err = r.Helm.Uninstall(helm.ReleaseName, "apps"); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.checkChart(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := r.Helm.Status(helm.ReleaseName, "apps")
+	if err != nil || rel == nil || rel.Info.Status != release.StatusDeployed {
+		t.Fatalf("release not installed again: %v %v", rel, err)
+	}
+	if got := conditionStatus(registerStatus, vaultv1alpha1.ConditionExternalSecretsInstalled); got != "True/Installed" {
+		t.Fatalf("%s = %q, want True/Installed", vaultv1alpha1.ConditionExternalSecretsInstalled, got)
+	}
+	if events := drainEvents(recorder); len(events) == 0 || events[0] != "Warning ChartDrifted release "+
+		helm.ReleaseName+" in namespace apps is missing" {
+		t.Fatalf("unexpected events %v", events)
+	}
+
+	// as is a release left failed
+	kubeClient.UpdateError = errors.New("admission webhook denied the request")
+	registerRequest.Spec.VaultAddr = "https://vault-2:8200"
+	if _, err = r.reconcileChart(ctx, registerRequest, registerStatus); err == nil {
+		t.Fatalf("expected the upgrade to fail")
+	}
+	kubeClient.UpdateError = nil
+	if err = r.checkChart(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	rel, err = r.Helm.Status(helm.ReleaseName, "apps")
+	if err != nil || rel == nil || rel.Info.Status != release.StatusDeployed {
+		t.Fatalf("failed release not installed again: %v %v", rel, err)
+	}
+	if events := drainEvents(recorder); len(events) == 0 || events[0] != "Warning ChartDrifted release "+
+		helm.ReleaseName+" in namespace apps is failed" {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// DefaultNodePort is the api server port used with the NodeLabels strategy
+const DefaultNodePort = 6443
+
+// endpointStrategy returns the strategy used to find kubernetes_host. Without one in the
+// spec an explicit k8sEndpoint is used, otherwise the control plane node labels
+func endpointStrategy(registerRequest *vaultv1alpha1.Register) string {
+	if len(registerRequest.Spec.K8SEndpointStrategy) != 0 {
+		return registerRequest.Spec.K8SEndpointStrategy
+	}
+	if len(registerRequest.Spec.K8SEndpoint) != 0 {
+		return vaultv1alpha1.EndpointStrategyExplicit
+	}
+	return vaultv1alpha1.EndpointStrategyNodeLabels
+}
+
+// kubernetesHost discovers the api server address vault uses for TokenReview
+func (r *RegisterReconciler) kubernetesHost(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (host string, err error) {
+	switch strategy := endpointStrategy(registerRequest); strategy {
+	case vaultv1alpha1.EndpointStrategyExplicit:
+		if len(registerRequest.Spec.K8SEndpoint) == 0 {
+			return host, permanentf("k8sEndpoint is required for the %s strategy", strategy)
+		}
+		return registerRequest.Spec.K8SEndpoint, nil
+	case vaultv1alpha1.EndpointStrategyKubernetesService:
+		return r.kubernetesServiceHost(ctx)
+	case vaultv1alpha1.EndpointStrategyOperatorConfig:
+		if len(r.RestConfigHost) == 0 {
+			return host, fmt.Errorf("operator rest config host is not known")
+		}
+		return httpsURL(r.RestConfigHost), nil
+	case vaultv1alpha1.EndpointStrategyClusterInfo:
+		return r.clusterInfoHost(ctx)
+	case vaultv1alpha1.EndpointStrategyNodeLabels:
+		masterNode, err := r.findMasterNodes(ctx)
+		if err != nil {
+			return host, err
+		}
+		if len(masterNode) == 0 {
+			return host, fmt.Errorf("no control plane nodes found")
+		}
+		port := int(registerRequest.Spec.K8SEndpointPort)
+		if port == 0 {
+			port = DefaultNodePort
+		}
+		return "https://" + net.JoinHostPort(masterNode, strconv.Itoa(port)), nil
+	default:
+		return host, permanentf("unsupported k8s endpoint strategy %s", strategy)
+	}
+}
+
+// kubernetesServiceHost uses the api server addresses behind the kubernetes Service. The
+// lowest address is picked so the result does not change between reconciles
+func (r *RegisterReconciler) kubernetesServiceHost(ctx context.Context) (host string, err error) {
+	endpoints := &v1.Endpoints{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "kubernetes"}, endpoints)
+	if err != nil {
+		return host, err
+	}
+	var hosts []string
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != "https" {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				hosts = append(hosts, "https://"+net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		return host, fmt.Errorf("no https addresses in endpoints default/kubernetes")
+	}
+	sort.Strings(hosts)
+	return hosts[0], nil
+}
+
+// clusterInfoHost reads the server published in the kube-public cluster-info ConfigMap
+func (r *RegisterReconciler) clusterInfoHost(ctx context.Context) (host string, err error) {
+	configMap := &v1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: "kube-public", Name: "cluster-info"}, configMap)
+	if err != nil {
+		return host, err
+	}
+	config, err := clientcmd.Load([]byte(configMap.Data["kubeconfig"]))
+	if err != nil {
+		return host, err
+	}
+	var names []string
+	for name, cluster := range config.Clusters {
+		if len(cluster.Server) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return host, fmt.Errorf("no server found in configmap kube-public/cluster-info")
+	}
+	sort.Strings(names)
+	return config.Clusters[names[0]].Server, nil
+}
+
+func httpsURL(host string) string {
+	if strings.HasPrefix(host, "https://") || strings.HasPrefix(host, "http://") {
+		return host
+	}
+	return "https://" + host
+}
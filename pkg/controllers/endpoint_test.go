@@ -0,0 +1,135 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const clusterInfoKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: ""
+  cluster:
+    server: https://api.example.com:443
+`
+
+func controlPlaneNode(name string, address string, labels map[string]string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: v1.NodeStatus{Addresses: []v1.NodeAddress{
+			{Type: v1.NodeInternalIP, Address: address},
+		}},
+	}
+}
+
+func TestKubernetesHost(t *testing.T) {
+	objects := []runtime.Object{
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{{IP: "10.0.0.12"}, {IP: "10.0.0.11"}},
+				Ports:     []v1.EndpointPort{{Name: "https", Port: 6443}},
+			}},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-info", Namespace: "kube-public"},
+			Data:       map[string]string{"kubeconfig": clusterInfoKubeconfig},
+		},
+		controlPlaneNode("worker", "10.0.1.1", map[string]string{"node-role.kubernetes.io/worker": "true"}),
+		controlPlaneNode("cp-b", "10.0.0.12", map[string]string{"node-role.kubernetes.io/control-plane": ""}),
+		controlPlaneNode("cp-a", "10.0.0.11", map[string]string{"node-role.kubernetes.io/master": ""}),
+	}
+	r := &RegisterReconciler{
+		Client:         fake.NewFakeClientWithScheme(scheme.Scheme, objects...),
+		RestConfigHost: "10.96.0.1:443",
+	}
+
+	tests := []struct {
+		name     string
+		spec     vaultv1alpha1.RegisterSpec
+		want     string
+		strategy string
+	}{
+		{
+			name:     "explicit endpoint",
+			spec:     vaultv1alpha1.RegisterSpec{K8SEndpoint: "https://lb.example.com:6443"},
+			want:     "https://lb.example.com:6443",
+			strategy: vaultv1alpha1.EndpointStrategyExplicit,
+		},
+		{
+			name:     "node labels by default",
+			want:     "https://10.0.0.11:6443",
+			strategy: vaultv1alpha1.EndpointStrategyNodeLabels,
+		},
+		{
+			name: "node labels with a custom port",
+			spec: vaultv1alpha1.RegisterSpec{K8SEndpointStrategy: vaultv1alpha1.EndpointStrategyNodeLabels,
+				K8SEndpointPort: 443},
+			want:     "https://10.0.0.11:443",
+			strategy: vaultv1alpha1.EndpointStrategyNodeLabels,
+		},
+		{
+			name:     "kubernetes service endpoints",
+			spec:     vaultv1alpha1.RegisterSpec{K8SEndpointStrategy: vaultv1alpha1.EndpointStrategyKubernetesService},
+			want:     "https://10.0.0.11:6443",
+			strategy: vaultv1alpha1.EndpointStrategyKubernetesService,
+		},
+		{
+			name:     "operator rest config",
+			spec:     vaultv1alpha1.RegisterSpec{K8SEndpointStrategy: vaultv1alpha1.EndpointStrategyOperatorConfig},
+			want:     "https://10.96.0.1:443",
+			strategy: vaultv1alpha1.EndpointStrategyOperatorConfig,
+		},
+		{
+			name:     "cluster-info configmap",
+			spec:     vaultv1alpha1.RegisterSpec{K8SEndpointStrategy: vaultv1alpha1.EndpointStrategyClusterInfo},
+			want:     "https://api.example.com:443",
+			strategy: vaultv1alpha1.EndpointStrategyClusterInfo,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registerRequest := &vaultv1alpha1.Register{Spec: test.spec}
+			got, err := r.kubernetesHost(context.Background(), registerRequest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("kubernetesHost() = %s, want %s", got, test.want)
+			}
+			if strategy := endpointStrategy(registerRequest); strategy != test.strategy {
+				t.Fatalf("endpointStrategy() = %s, want %s", strategy, test.strategy)
+			}
+		})
+	}
+
+	registerRequest := &vaultv1alpha1.Register{Spec: vaultv1alpha1.RegisterSpec{
+		K8SEndpointStrategy: vaultv1alpha1.EndpointStrategyExplicit}}
+	if _, err := r.kubernetesHost(context.Background(), registerRequest); !isPermanent(err) {
+		t.Fatalf("expected a permanent error without k8sEndpoint, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// loginTokenExpiration is the shortest lifetime the TokenRequest API accepts
+const loginTokenExpiration = int64(600)
+
+// verifyLogin logs in to the auth mount as the service account bound to the role. Vault reviews
+// the JWT against the api server, so this proves the written config works from vault's side.
+// The outcome is only reported in the LoginVerified condition and never fails the reconcile.
+// The JWT is sent to the vault of the Register, so it is only minted for the service account
+// the operator created for the Register. It is requested for the audience of the role, or the
+// api server audiences the workloads log in with when the role sets none
+func (r *RegisterReconciler) verifyLogin(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus, v *vault.VaultRegister) {
+	if r.KubeClient == nil {
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionLoginVerified,
+			metav1.ConditionUnknown, "TokenRequestUnavailable", "no kubernetes clientset configured for token requests")
+		return
+	}
+	sa := &v1.ServiceAccount{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: registerRequest.Spec.Namespace,
+		Name: registerRequest.Spec.ServiceAccount}, sa)
+	if err == nil && !ownedBy(registerRequest, sa.Labels) {
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionLoginVerified,
+			metav1.ConditionUnknown, "ServiceAccountNotManaged", fmt.Sprintf(
+				"service account %s/%s was not created for this Register", sa.Namespace, sa.Name))
+		return
+	}
+	wasVerified := false
+	if existing := registerStatus.GetCondition(vaultv1alpha1.ConditionLoginVerified); existing != nil {
+		wasVerified = existing.Status == metav1.ConditionTrue
+	}
+
+	if err == nil {
+		err = r.loginAsServiceAccount(registerRequest, v, loginAudience(registerRequest, v))
+	}
+	if err != nil {
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionLoginVerified,
+			metav1.ConditionFalse, "LoginFailed", err.Error())
+		r.Recorder.Event(registerRequest, v1.EventTypeWarning, "LoginFailed", err.Error())
+		return
+	}
+	message := fmt.Sprintf("service account %s/%s logged in to auth/%s with role %s",
+		registerRequest.Spec.Namespace, registerRequest.Spec.ServiceAccount, v.Mount, registerRequest.Spec.RoleName)
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionLoginVerified,
+		metav1.ConditionTrue, "LoginSucceeded", message)
+	if !wasVerified {
+		r.Recorder.Event(registerRequest, v1.EventTypeNormal, "LoginVerified", message)
+	}
+}
+
+// loginAudience is the audience of the role bound to the service account
+func loginAudience(registerRequest *vaultv1alpha1.Register, v *vault.VaultRegister) string {
+	for _, role := range v.Roles {
+		if role.Name == registerRequest.Spec.RoleName {
+			return role.Options.Audience
+		}
+	}
+	return ""
+}
+
+// loginAsServiceAccount requests a short lived JWT for the bound service account and logs in with
+// it. Without an audience the api server picks its own, like for the tokens mounted into pods
+func (r *RegisterReconciler) loginAsServiceAccount(registerRequest *vaultv1alpha1.Register,
+	v *vault.VaultRegister, audience string) (err error) {
+	expirationSeconds := loginTokenExpiration
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+	}
+	if len(audience) != 0 {
+		tokenRequest.Spec.Audiences = []string{audience}
+	}
+	tokenRequest, err = r.KubeClient.CoreV1().ServiceAccounts(registerRequest.Spec.Namespace).
+		CreateToken(registerRequest.Spec.ServiceAccount, tokenRequest)
+	if err != nil {
+		return fmt.Errorf("unable to request a token for service account %s/%s: %v",
+			registerRequest.Spec.Namespace, registerRequest.Spec.ServiceAccount, err)
+	}
+	return v.VerifyLogin(registerRequest.Spec.RoleName, tokenRequest.Status.Token)
+}
@@ -0,0 +1,132 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestVerifyLogin(t *testing.T) {
+	reviewFails := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data":{"id":"root"}}`))
+		case "/v1/auth/k8s-demo/login":
+			if reviewFails {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"errors":["dial tcp 10.0.0.1:6443: connect: connection refused"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.login"}}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", Generation: 1, UID: "register-uid"},
+		Spec:       vaultv1alpha1.RegisterSpec{ServiceAccount: "app", Namespace: "apps", RoleName: "demo"},
+	}
+	var audiences []string
+	requests := 0
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tokenRequest := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		audiences = tokenRequest.Spec.Audiences
+		requests++
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "app-jwt"}}, nil
+	})
+	sa := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps",
+		Labels: map[string]string{registerUIDLabel: "register-uid"}}}
+	r := &RegisterReconciler{
+		Client:     fake.NewFakeClientWithScheme(scheme.Scheme, sa),
+		Recorder:   record.NewFakeRecorder(10),
+		KubeClient: kubeClient,
+	}
+	v := &vault.VaultRegister{
+		VaultAddress: server.URL,
+		Mount:        "k8s-demo",
+		Auth:         &vault.TokenAuth{Token: "root"},
+		Roles:        []vault.Role{{Name: "demo", Options: vault.RoleOptions{Audience: "vault"}}},
+	}
+
+	registerStatus := &vaultv1alpha1.RegisterStatus{}
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	condition := registerStatus.GetCondition(vaultv1alpha1.ConditionLoginVerified)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != "LoginSucceeded" {
+		t.Fatalf("unexpected condition after login: %+v", condition)
+	}
+	if len(audiences) != 1 || audiences[0] != "vault" {
+		t.Fatalf("token requested for audiences %v, want the role audience", audiences)
+	}
+
+	reviewFails = true
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	condition = registerStatus.GetCondition(vaultv1alpha1.ConditionLoginVerified)
+	if condition.Status != metav1.ConditionFalse || condition.Reason != "LoginFailed" ||
+		!strings.Contains(condition.Message, "connection refused") {
+		t.Fatalf("vault error not reported: %+v", condition)
+	}
+
+	// a role without an audience is checked with the api server audiences, as workloads log in
+	reviewFails = false
+	v.Roles[0].Options.Audience = ""
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	condition = registerStatus.GetCondition(vaultv1alpha1.ConditionLoginVerified)
+	if condition.Status != metav1.ConditionTrue || len(audiences) != 0 {
+		t.Fatalf("expected the login to be checked without an audience: %+v %v", condition, audiences)
+	}
+
+	// no token is minted for a service account the operator did not create
+	requests = 0
+	v.Roles[0].Options.Audience = "vault"
+	sa.Labels = nil
+	if err := r.Update(ctx, sa); err != nil {
+		t.Fatal(err)
+	}
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	condition = registerStatus.GetCondition(vaultv1alpha1.ConditionLoginVerified)
+	if condition.Reason != "ServiceAccountNotManaged" || requests != 0 {
+		t.Fatalf("expected the login to be skipped for a foreign service account: %+v", condition)
+	}
+
+	r.KubeClient = nil
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	if condition := registerStatus.GetCondition(vaultv1alpha1.ConditionLoginVerified); condition.Status != metav1.ConditionUnknown {
+		t.Fatalf("expected unknown without a clientset: %+v", condition)
+	}
+}
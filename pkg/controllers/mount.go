@@ -0,0 +1,119 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultMountPathTemplate includes the namespace as Registers with the same name may
+// exist in several namespaces
+const DefaultMountPathTemplate = "k8s-{{clusterName}}-{{namespace}}-{{registerName}}"
+
+// annotations which tracked the auth mount before it was recorded in the status only
+const (
+	legacyMountAnnotation       = "mountPath"
+	legacyAuthEnabledAnnotation = "auth-enabled"
+)
+
+// clusterNameLength is how much of the cluster ID is used as the default cluster name
+const clusterNameLength = 8
+
+// OperatorVersion is recorded on the auth mounts created by the operator. It is passed via build flags
+var OperatorVersion = "dev"
+
+var invalidMountChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// desiredMountPath returns the auth mount the Register should use. Registers created
+// before mount paths were configurable keep the mount recorded in their status
+func (r *RegisterReconciler) desiredMountPath(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (mount string, err error) {
+	if len(registerRequest.Spec.MountPath) != 0 {
+		return strings.Trim(registerRequest.Spec.MountPath, "/"), nil
+	}
+	template := registerRequest.Spec.MountPathTemplate
+	if len(template) == 0 {
+		if current := registerRequest.Status.VaultAuthMount; len(current) != 0 {
+			return current, nil
+		}
+		template = DefaultMountPathTemplate
+	}
+	clusterName, err := r.clusterName(ctx)
+	if err != nil {
+		return mount, err
+	}
+	return renderMountPath(template, clusterName, registerRequest), nil
+}
+
+// clusterName identifies this cluster in mount paths. Unless configured a prefix of the
+// cluster ID is used, which is stable across operator restarts and unique per cluster
+func (r *RegisterReconciler) clusterName(ctx context.Context) (name string, err error) {
+	if len(r.ClusterName) != 0 {
+		return r.ClusterName, nil
+	}
+	name, err = r.clusterID(ctx)
+	if len(name) > clusterNameLength {
+		name = name[:clusterNameLength]
+	}
+	return name, err
+}
+
+// clusterID is the UID of the kube-system namespace
+func (r *RegisterReconciler) clusterID(ctx context.Context) (id string, err error) {
+	ns := &v1.Namespace{}
+	if err = r.Get(ctx, types.NamespacedName{Name: "kube-system"}, ns); err != nil {
+		return id, err
+	}
+	return string(ns.UID), nil
+}
+
+// mountOwner is the ownership recorded on the auth mount of the Register
+func (r *RegisterReconciler) mountOwner(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (owner vault.MountOwner, err error) {
+	owner.ClusterID, err = r.clusterID(ctx)
+	owner.RegisterUID = string(registerRequest.UID)
+	owner.OperatorVersion = OperatorVersion
+	return owner, err
+}
+
+func isMountNotOwned(err error) bool {
+	var notOwned *vault.MountNotOwnedError
+	return errors.As(err, &notOwned)
+}
+
+// renderMountPath substitutes the template variables. Each value is reduced to
+// characters which are safe in a vault path
+func renderMountPath(template string, clusterName string, registerRequest *vaultv1alpha1.Register) string {
+	replacer := strings.NewReplacer(
+		"{{clusterName}}", sanitizeMountSegment(clusterName),
+		"{{namespace}}", sanitizeMountSegment(registerRequest.Namespace),
+		"{{registerName}}", sanitizeMountSegment(registerRequest.Name),
+	)
+	return strings.Trim(replacer.Replace(template), "/")
+}
+
+func sanitizeMountSegment(value string) string {
+	return strings.Trim(invalidMountChars.ReplaceAllString(strings.ToLower(value), "-"), "-")
+}
@@ -0,0 +1,171 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDesiredMountPath(t *testing.T) {
+	r := &RegisterReconciler{ClusterName: "Prod.EU-1"}
+	register := func() *vaultv1alpha1.Register {
+		return &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{
+			Name: "external-secrets", Namespace: "default", Annotations: map[string]string{},
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(registerRequest *vaultv1alpha1.Register)
+		want   string
+	}{
+		{
+			name:   "default template",
+			modify: func(registerRequest *vaultv1alpha1.Register) {},
+			want:   "k8s-prod-eu-1-default-external-secrets",
+		},
+		{
+			name: "existing mount is kept",
+			modify: func(registerRequest *vaultv1alpha1.Register) {
+				registerRequest.Status.VaultAuthMount = "k8sabcdefghij"
+			},
+			want: "k8sabcdefghij",
+		},
+		{
+			name: "annotation is ignored",
+			modify: func(registerRequest *vaultv1alpha1.Register) {
+				registerRequest.Annotations[legacyMountAnnotation] = "k8sabcdefghij"
+			},
+			want: "k8s-prod-eu-1-default-external-secrets",
+		},
+		{
+			name: "template overrides the existing mount",
+			modify: func(registerRequest *vaultv1alpha1.Register) {
+				registerRequest.Status.VaultAuthMount = "k8sabcdefghij"
+				registerRequest.Spec.MountPathTemplate = "clusters/{{clusterName}}/{{registerName}}"
+			},
+			want: "clusters/prod-eu-1/external-secrets",
+		},
+		{
+			name: "explicit mount path",
+			modify: func(registerRequest *vaultv1alpha1.Register) {
+				registerRequest.Spec.MountPath = "/kubernetes-prod/"
+				registerRequest.Spec.MountPathTemplate = "ignored"
+			},
+			want: "kubernetes-prod",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registerRequest := register()
+			test.modify(registerRequest)
+			got, err := r.desiredMountPath(context.Background(), registerRequest)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("desiredMountPath() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrepareVaultClientAdoptsRecordedMountOnly(t *testing.T) {
+	ctx := context.Background()
+	token := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultSecret, Namespace: operatorNamespace()},
+		Data:       map[string][]byte{tokenKey: []byte("s.token")},
+	}
+	kubeSystem := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "cluster-uid"}}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, token, kubeSystem),
+		ClusterName: "prod"}
+	registerRequest := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{
+		Name: "demo", Namespace: "default", UID: "register-uid",
+		Annotations: map[string]string{legacyMountAnnotation: "kubernetes", legacyAuthEnabledAnnotation: "true"},
+	}}
+
+	// annotations can be set by anyone who can edit the Register
+	v, err := r.prepareVaultClient(ctx, registerRequest, &registerRequest.Status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Mount != "k8s-prod-default-demo" || v.AdoptUnmarked {
+		t.Fatalf("unexpected mount %s, adopt %v", v.Mount, v.AdoptUnmarked)
+	}
+
+	registerStatus := &vaultv1alpha1.RegisterStatus{VaultAuthMount: "k8sabcdefghij"}
+	v, err = r.prepareVaultClient(ctx, registerRequest, registerStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Mount != "k8sabcdefghij" || !v.AdoptUnmarked || v.Owner.ClusterID != "cluster-uid" {
+		t.Fatalf("unexpected mount %s, adopt %v, owner %+v", v.Mount, v.AdoptUnmarked, v.Owner)
+	}
+}
+
+func TestPrepareVaultClientNamespace(t *testing.T) {
+	var namespaces []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		namespaces = append(namespaces, req.Header.Get("X-Vault-Namespace"))
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"token/":{"type":"token"}}}`))
+	}))
+	defer server.Close()
+	os.Setenv("VAULT_NAMESPACE", "from-env")
+	defer os.Unsetenv("VAULT_NAMESPACE")
+
+	ctx := context.Background()
+	token := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultSecret, Namespace: operatorNamespace()},
+		Data:       map[string][]byte{tokenKey: []byte("s.token")},
+	}
+	kubeSystem := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "cluster-uid"}}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, token, kubeSystem),
+		ClusterName: "prod"}
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "register-uid"},
+		Spec:       vaultv1alpha1.RegisterSpec{VaultAddr: server.URL},
+	}
+
+	for _, namespace := range []string{"team/apps", ""} {
+		namespaces = nil
+		registerRequest.Spec.VaultNamespace = namespace
+		v, err := r.prepareVaultClient(ctx, registerRequest, &registerRequest.Status)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = v.UnregisterCluster(); err != nil {
+			t.Fatal(err)
+		}
+		// the header comes from the spec alone, never from VAULT_NAMESPACE
+		if !reflect.DeepEqual(namespaces, []string{namespace}) {
+			t.Fatalf("namespaces %q sent, want %q", namespaces, namespace)
+		}
+	}
+}
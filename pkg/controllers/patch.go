@@ -0,0 +1,107 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// patchRegister writes the changes made during a reconcile. Status goes through the
+// status subresource and only annotations and finalizers are patched on the object,
+// so the spec is never written back. Conflicts are retried against the latest version
+func (r *RegisterReconciler) patchRegister(ctx context.Context, original *vaultv1alpha1.Register,
+	registerRequest *vaultv1alpha1.Register) (err error) {
+	current := original.DeepCopy()
+	if !equality.Semantic.DeepEqual(original.Status, registerRequest.Status) {
+		err = r.retryPatch(ctx, current, func() {
+			// status is only ever written by this controller
+			current.Status = registerRequest.Status
+		}, func(patch client.Patch) error {
+			return r.Status().Patch(ctx, current, patch)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if equality.Semantic.DeepEqual(original.Annotations, registerRequest.Annotations) &&
+		equality.Semantic.DeepEqual(original.Finalizers, registerRequest.Finalizers) {
+		return nil
+	}
+	return r.retryPatch(ctx, current, func() {
+		applyMetadata(current, original, registerRequest)
+	}, func(patch client.Patch) error {
+		return r.Patch(ctx, current, patch)
+	})
+}
+
+// retryPatch applies mutate to current and writes it. The patch carries the
+// resourceVersion so a concurrent write fails with a conflict instead of being
+// overwritten, in which case current is refreshed and mutated again
+func (r *RegisterReconciler) retryPatch(ctx context.Context, current *vaultv1alpha1.Register,
+	mutate func(), write func(patch client.Patch) error) (err error) {
+	refresh := false
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		if refresh {
+			key := types.NamespacedName{Namespace: current.Namespace, Name: current.Name}
+			*current = vaultv1alpha1.Register{}
+			if err := r.Get(ctx, key, current); err != nil {
+				return err
+			}
+		}
+		refresh = true
+
+		base := current.DeepCopy()
+		// an empty resourceVersion in the base keeps it in the merge patch as a precondition
+		base.ResourceVersion = ""
+		mutate()
+		return write(client.MergeFrom(base))
+	})
+	return client.IgnoreNotFound(err)
+}
+
+// applyMetadata replays the annotation and finalizer changes made on registerRequest
+// onto latest, leaving changes made by other writers in place
+func applyMetadata(latest *vaultv1alpha1.Register, original *vaultv1alpha1.Register,
+	registerRequest *vaultv1alpha1.Register) {
+	for key := range original.Annotations {
+		if _, ok := registerRequest.Annotations[key]; !ok {
+			delete(latest.Annotations, key)
+		}
+	}
+	for key, value := range registerRequest.Annotations {
+		if original.Annotations[key] != value {
+			if latest.Annotations == nil {
+				latest.Annotations = make(map[string]string)
+			}
+			latest.Annotations[key] = value
+		}
+	}
+
+	if containsString(registerRequest.Finalizers, finalizer) {
+		controllerutil.AddFinalizer(latest, finalizer)
+	} else {
+		controllerutil.RemoveFinalizer(latest, finalizer)
+	}
+}
@@ -0,0 +1,56 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyMetadata(t *testing.T) {
+	original := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{"token": "s.secret", "mountPath": "k8sabc"},
+	}}
+	registerRequest := original.DeepCopy()
+	delete(registerRequest.Annotations, "token")
+	registerRequest.Annotations["auth-enabled"] = "true"
+	registerRequest.Finalizers = []string{finalizer}
+
+	// another writer added an annotation and a finalizer in the meantime
+	latest := original.DeepCopy()
+	latest.Annotations["team"] = "platform"
+	latest.Finalizers = []string{"other"}
+
+	applyMetadata(latest, original, registerRequest)
+
+	wantAnnotations := map[string]string{"mountPath": "k8sabc", "auth-enabled": "true", "team": "platform"}
+	if !reflect.DeepEqual(latest.Annotations, wantAnnotations) {
+		t.Fatalf("annotations = %v, want %v", latest.Annotations, wantAnnotations)
+	}
+	if wantFinalizers := []string{"other", finalizer}; !reflect.DeepEqual(latest.Finalizers, wantFinalizers) {
+		t.Fatalf("finalizers = %v, want %v", latest.Finalizers, wantFinalizers)
+	}
+
+	registerRequest.Finalizers = nil
+	applyMetadata(latest, original, registerRequest)
+	if wantFinalizers := []string{"other"}; !reflect.DeepEqual(latest.Finalizers, wantFinalizers) {
+		t.Fatalf("finalizers = %v, want %v", latest.Finalizers, wantFinalizers)
+	}
+}
@@ -18,49 +18,70 @@ package controllers
 
 import (
 	"context"
-	"fmt"
-	"math/rand"
-	"strconv"
+	"sort"
 	"strings"
+	"time"
 
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
-	"os"
-
-	"k8s.io/apimachinery/pkg/types"
-
 	"github.com/go-logr/logr"
 	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
 	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
 	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	"helm.sh/helm/v3/pkg/release"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 const (
 	DefaultNamespace = "vault-glue-operator"
 	DefaultSecret    = "vault-token"
 	finalizer        = "vault-glue-operator"
+	// registerUIDLabel marks service accounts created by the operator
+	registerUIDLabel = "vault.cattle.io/register-uid"
 )
 
 // RegisterReconciler reconciles a Register object
 type RegisterReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// ClusterName is used in auth mount names. Defaults to a prefix of the kube-system namespace UID
+	ClusterName string
+	// KubeClient requests short lived tokens and watches the Secrets and ConfigMaps of the Registers
+	KubeClient kubernetes.Interface
+	// RestConfigHost is the api server the operator talks to, used by the OperatorConfig strategy
+	RestConfigHost string
+	// Helm installs the external-secrets chart
+	Helm *helm.Client
+	// DefaultChart is the chart used when the Register sets none, the bundled chart when empty
+	DefaultChart vaultv1alpha1.ChartSpec
+	// AllowedChartRepositories are the repository prefixes a Register may install its chart from
+	AllowedChartRepositories []string
+	// ChartCacheDir keeps charts downloaded from repositories
+	ChartCacheDir string
 }
 
 // +kubebuilder:rbac:groups=vault.cattle.io,resources=registers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=vault.cattle.io,resources=registers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=external-secrets.io,resources=secretstores;clustersecretstores,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=secrets-store.csi.x-k8s.io,resources=secretproviderclasses,verbs=get;list;watch;create;update;patch;delete
 // Reconcile runs the reconilliation loop
 func (r *RegisterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("register", req.NamespacedName)
-	var requeue bool
+	var result ctrl.Result
 	registerRequest := &vaultv1alpha1.Register{}
 
 	if err := r.Get(ctx, req.NamespacedName, registerRequest); err != nil {
@@ -71,160 +92,201 @@ func (r *RegisterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if registerRequest.Annotations == nil {
+		registerRequest.Annotations = make(map[string]string)
+	}
+	// the spec is never written back, the defaults only apply to this reconcile
+	registerRequest.SetDefaults()
+	original := registerRequest.DeepCopy()
+	// tokens are never persisted on the object and the mount is tracked in the status.
+	// Drop any annotations left behind by older versions
+	delete(registerRequest.Annotations, legacyTokenAnnotation)
+	delete(registerRequest.Annotations, legacyMountAnnotation)
+	delete(registerRequest.Annotations, legacyAuthEnabledAnnotation)
 	registerStatus := registerRequest.Status.DeepCopy()
 	if registerRequest.DeletionTimestamp.IsZero() {
-		switch status := registerStatus.Status; status {
-		case "":
-			//Lets check if VaultRegoSecret exists//
-			token, err := r.checkVaultSecretExists(ctx)
+		if isStalled(registerRequest) {
+			// retrying cannot help, wait for the spec to change
+			return ctrl.Result{}, nil
+		}
+		if err := r.migrateLegacyStatus(ctx, registerRequest, registerStatus); err != nil {
+			// the steps below set the Register up again instead
+			log.Error(err, "unable to migrate the status of an earlier version")
+		}
+		registerRequest.Status = *registerStatus
+		if !isReady(registerRequest) {
+			log.Info("Reconciling vault integration")
+			err := r.runSteps(ctx, registerRequest, registerStatus)
 			if err != nil {
-				log.Error(err, "Error during vault registeration secret check")
-				registerStatus.Message = err.Error()
-			} else {
-				registerStatus.Message = ""
-				registerRequest.Annotations["token"] = token
-				registerStatus.Status = "VaultTokenPresent"
+				log.Error(err, "Error during reconcile")
 			}
-		case "VaultTokenPresent":
-			// Create service account
-			log.Info("Managing service account")
-			err := r.createSA(ctx, registerRequest)
-			if err != nil {
-				registerStatus.Message = err.Error()
-				log.Error(err, "Error during SA creation")
-			} else {
-				registerStatus.Message = ""
-				registerStatus.Status = "ServiceAccountCreated"
+			result.RequeueAfter = recordAttempt(registerRequest, registerStatus, err)
+			if err == nil {
+				result.RequeueAfter = driftCheckInterval(registerRequest)
 			}
-
-		case "ServiceAccountCreated":
-			// Perform Vault rego
-			log.Info("Managing setting up vault auth")
-			v, err := r.prepareVaultRequest(ctx, registerRequest)
-			var authEnabled, skipAuth bool
-			if err != nil {
-				log.Error(err, "Error during Vault setup")
-				registerStatus.Message = err.Error()
-			} else {
-				authStringStatus, ok := registerRequest.Annotations["auth-enabled"]
-				if ok {
-					skipAuth, err = strconv.ParseBool(authStringStatus)
-					if err != nil {
-						registerStatus.Message = err.Error()
-					}
-				}
-				authEnabled, err = v.RegisterCluster(skipAuth)
-				if err != nil {
-					registerStatus.Message = err.Error()
-					if strings.Contains(err.Error(), "path is already in use at") {
-						delete(registerRequest.Annotations, "mountPath")
+		} else {
+			// rotated reviewer credentials are written to vault as soon as they are noticed
+			readyErr := r.rotateReviewer(ctx, registerRequest, registerStatus)
+			if readyErr != nil {
+				log.Error(readyErr, "Error during token reviewer rotation")
+			}
+			// values read from ConfigMaps and Secrets are applied as soon as they change
+			if err := r.refreshChartValues(ctx, registerRequest, registerStatus); err != nil {
+				log.Error(err, "Error during chart values refresh")
+				readyErr = err
+			}
+			result.RequeueAfter = recordReadyAttempt(registerStatus, readyErr)
+			// periodically verify vault has not been changed behind our back
+			if interval := driftCheckInterval(registerRequest); interval != 0 {
+				due := nextDriftCheck(registerRequest)
+				if due <= 0 {
+					log.Info("Checking vault for drift")
+					if err := r.checkDrift(ctx, registerRequest, registerStatus); err != nil {
+						log.Error(err, "Error during vault drift check")
 					}
-				} else {
-					registerStatus.Message = ""
-					registerStatus.Status = "VaultRegistrationComplete"
-					registerStatus.VaultAuthMount = registerRequest.Annotations["mountPath"]
-					if authEnabled {
-						registerRequest.Annotations["auth-enabled"] = "true"
+					if err := r.checkChart(ctx, registerRequest, registerStatus); err != nil {
+						log.Error(err, "Error during chart drift check")
 					}
+					due = interval
 				}
+				result.RequeueAfter = soonest(result.RequeueAfter, due)
 			}
-		case "VaultRegistrationComplete":
-			//Lets deploy external secrets helm chart
-			if registerRequest.Spec.SkipExternalSecretInstall {
-				log.Info("Help chart skipped")
-				registerStatus.Message = "External Secret Install Skipped"
-				registerStatus.Status = "Processed"
-			} else {
-				log.Info("Installing helm chart")
-				// perform helm install
-				output, err := r.installChart(ctx, registerRequest)
-				if err != nil {
-					log.Error(err, string(output))
-					registerStatus.Message = err.Error()
-				} else {
-					log.Info(string(output))
-					registerStatus.Message = ""
-					registerStatus.HelmStatus = "Installed"
-					registerStatus.Status = "Processed"
-				}
-			}
-		case "Processed":
-			return ctrl.Result{}, nil
-
+			result.RequeueAfter = soonest(result.RequeueAfter, nextReviewerRenewal(registerRequest, registerStatus))
 		}
 		registerRequest.Status = *registerStatus
 		controllerutil.AddFinalizer(registerRequest, finalizer)
-		requeue = true
 
 	} else {
 		if containsString(registerRequest.ObjectMeta.Finalizers, finalizer) {
 			// lets delete the instance //
 			log.Info("Cleaning up associated resources")
+			var cleanupErr error
 			registerStatus = registerRequest.Status.DeepCopy()
-			if registerStatus.HelmStatus == "Installed" {
+			// the stores go first, their CRDs are removed along with the chart
+			if applied := registerStatus.Applied; applied != nil && len(applied.BackendObjects) != 0 {
+				remaining, err := r.deleteBackendObjects(ctx, registerRequest, applied.BackendObjects)
+				applied.BackendObjects = remaining
+				if err != nil {
+					setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+						"CleanupFailed", err.Error())
+					r.Recorder.Event(registerRequest, v1.EventTypeWarning, "CleanupFailed", err.Error())
+					cleanupErr = err
+				}
+			}
+			if registerStatus.HelmStatus == "Installed" && len(appliedSpec(registerStatus).BackendObjects) == 0 {
 				// lets remove the chart //
-				output, err := r.uninstallChart(ctx, registerRequest)
-				log.Info(string(output))
+				err := r.uninstallChart(ctx, registerRequest, appliedNamespace(registerRequest),
+					appliedSpec(registerStatus).SecretsBackend)
 				if err != nil {
-					registerStatus.Message = err.Error()
-					requeue = true
+					setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+						"CleanupFailed", err.Error())
+					cleanupErr = err
 				} else {
 					registerStatus.HelmStatus = ""
 				}
 			}
 
 			if registerStatus.VaultAuthMount != "" {
-				v, err := r.prepareVaultRequest(ctx, registerRequest)
+				v, err := r.prepareVaultClient(ctx, registerRequest, registerStatus)
+				if applied := registerStatus.Applied; applied != nil && len(applied.VaultAddr) != 0 {
+					v.VaultAddress = applied.VaultAddr
+				}
 				if err != nil {
-					registerStatus.Message = err.Error()
-					requeue = true
+					setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+						"CleanupFailed", err.Error())
+					r.Recorder.Event(registerRequest, v1.EventTypeWarning, "CleanupFailed", err.Error())
+					cleanupErr = err
 				} else {
 					err = v.UnregisterCluster()
+					if isMountNotOwned(err) {
+						// someone else's mount is left alone and must not block the deletion
+						r.Recorder.Event(registerRequest, v1.EventTypeWarning, "MountNotOwned", err.Error())
+						err = nil
+					}
 					if err != nil {
-						registerStatus.Message = err.Error()
-						requeue = true
+						setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+							"CleanupFailed", err.Error())
+						r.Recorder.Eventf(registerRequest, v1.EventTypeWarning, "CleanupFailed",
+							"unable to disable auth mount %s: %v", registerStatus.VaultAuthMount, err)
+						cleanupErr = err
+					} else {
+						r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "VaultAuthDisabled",
+							"auth mount %s disabled", registerStatus.VaultAuthMount)
 					}
 					registerStatus.VaultAuthMount = ""
 				}
 			}
+
+			// the reviewer is only removed once vault no longer uses its JWT
+			if applied := registerStatus.Applied; applied != nil && registerStatus.VaultAuthMount == "" {
+				if err := r.cleanupReviewer(ctx, registerRequest, applied.ReviewerServiceAccount); err != nil {
+					setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+						"CleanupFailed", err.Error())
+					r.Recorder.Event(registerRequest, v1.EventTypeWarning, "CleanupFailed", err.Error())
+					cleanupErr = err
+				} else {
+					applied.ReviewerServiceAccount = ""
+				}
+			}
+			if cleanupErr != nil {
+				// cleanup failures are never permanent or the finalizer would never be removed
+				registerStatus.Attempts++
+				result.RequeueAfter = scheduleRetry(registerStatus)
+			}
 			registerRequest.Status = *registerStatus
 		}
-		if registerStatus.HelmStatus == "" && registerStatus.VaultAuthMount == "" {
+		if registerStatus.HelmStatus == "" && registerStatus.VaultAuthMount == "" &&
+			len(appliedSpec(registerStatus).ReviewerServiceAccount) == 0 &&
+			len(appliedSpec(registerStatus).BackendObjects) == 0 {
 			controllerutil.RemoveFinalizer(registerRequest, finalizer)
 		}
 	}
 
-	return ctrl.Result{Requeue: requeue}, r.Update(ctx, registerRequest)
+	return result, r.patchRegister(ctx, original, registerRequest)
 }
 
 // SetupWithManager will setup the controller to watch objects
 func (r *RegisterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// only the Secrets and ConfigMaps the Registers depend on are watched, not the whole cluster
+	reviewerSecrets, rootCA, valuesConfigMaps, valuesSecrets, err := r.watchSources(mgr)
+	if err != nil {
+		return err
+	}
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&vaultv1alpha1.Register{}).
+		Watches(reviewerSecrets,
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.registersForReviewerSecret)}).
+		Watches(rootCA,
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.registersForRootCA)}).
+		Watches(valuesConfigMaps,
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.registersForValues)}).
+		Watches(valuesSecrets,
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.registersForValues)}).
+		// status and annotation updates made here must not bypass the backoff
+		WithEventFilter(registerGenerationChanged()).
 		Complete(r)
 }
 
-func (r *RegisterReconciler) checkVaultSecretExists(ctx context.Context) (token string, err error) {
-	namespace, ok := os.LookupEnv("NAMESPACE")
-	if !ok {
-		namespace = DefaultNamespace
-	}
-
-	if len(namespace) == 0 {
-		namespace = DefaultNamespace
+// registerGenerationChanged drops Register updates which did not change the spec. Events
+// of other watched objects are always passed on
+func registerGenerationChanged() predicate.Predicate {
+	generationChanged := predicate.GenerationChangedPredicate{}
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if _, ok := e.ObjectNew.(*vaultv1alpha1.Register); !ok {
+				return true
+			}
+			return generationChanged.Update(e)
+		},
 	}
+}
 
-	secret := &v1.Secret{}
-	err = r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: DefaultSecret}, secret)
-	if err != nil {
-		return token, err
-	}
-	if tokenByte, ok := secret.Data["token"]; !ok {
-		return token, fmt.Errorf("token key not found in secret %s in namespace %s", DefaultSecret, namespace)
-	} else {
-		token = string(tokenByte)
+// soonest returns the shorter of two requeue delays, ignoring unset ones
+func soonest(a time.Duration, b time.Duration) time.Duration {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
 	}
-	return token, err
+	return a
 }
 
 func (r *RegisterReconciler) createSA(ctx context.Context, registerRequest *vaultv1alpha1.Register) (err error) {
@@ -237,6 +299,9 @@ func (r *RegisterReconciler) createSA(ctx context.Context, registerRequest *vaul
 	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, ns, func() error {
 		return nil
 	})
+	if err != nil {
+		return err
+	}
 
 	sa := &v1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
@@ -245,59 +310,161 @@ func (r *RegisterReconciler) createSA(ctx context.Context, registerRequest *vaul
 		},
 	}
 	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, sa, func() error {
+		// only service accounts created by the operator are labelled, and later cleaned up
+		if sa.CreationTimestamp.IsZero() {
+			if sa.Labels == nil {
+				sa.Labels = make(map[string]string)
+			}
+			sa.Labels[registerUIDLabel] = string(registerRequest.UID)
+		}
 		return nil
 	})
 	return err
 }
 
-func (r *RegisterReconciler) prepareVaultRequest(ctx context.Context,
-	registerRequest *vaultv1alpha1.Register) (v *vault.VaultRegister, err error) {
-	sa := &v1.ServiceAccount{}
-	err = r.Get(ctx, types.NamespacedName{Namespace: registerRequest.Spec.Namespace,
-		Name: registerRequest.Spec.ServiceAccount}, sa)
+func (r *RegisterReconciler) prepareVaultRequest(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (v *vault.VaultRegister, err error) {
+	credential, err := r.reviewerCredentials(ctx, registerRequest, true)
 	if err != nil {
 		return v, err
 	}
-	var typedSecret types.NamespacedName
-	for _, secret := range sa.Secrets {
-		typedSecret.Name = secret.Name
-		typedSecret.Namespace = registerRequest.Spec.Namespace
-	}
 
-	saSecret := &v1.Secret{}
-	err = r.Get(ctx, typedSecret, saSecret)
+	v, err = r.prepareVaultClient(ctx, registerRequest, registerStatus)
+	if err != nil {
+		return v, err
+	}
+	v.SAToken = credential.Token
+	v.SATokenExpiry = credential.Expiry
+	v.K8SCACert = credential.CACert
+	v.K8SHost, err = r.kubernetesHost(ctx, registerRequest)
 	if err != nil {
 		return v, err
 	}
+	v.SAName = registerRequest.Spec.ServiceAccount
+	v.Namespace = registerRequest.Spec.Namespace
+	v.Roles, err = desiredRoles(registerRequest)
+	return v, err
+}
 
+// prepareVaultClient sets up what is needed to talk to vault and manage the auth mount.
+// It does not depend on the service account so it can also be used during cleanup
+func (r *RegisterReconciler) prepareVaultClient(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (v *vault.VaultRegister, err error) {
 	v = &vault.VaultRegister{}
-	v.SAToken = string(saSecret.Data["token"])
-	v.K8SCACert = string(saSecret.Data["ca.crt"])
-	if len(registerRequest.Spec.K8SEndpoint) != 0 {
-		v.K8SHost = registerRequest.Spec.K8SEndpoint
-	} else {
-		masterNode, err := r.findMasterNodes(ctx)
+	v.Auth, err = r.resolveAuthenticator(ctx, registerRequest)
+	if err != nil {
+		return v, err
+	}
+	v.VaultAddress = registerRequest.Spec.VaultAddr
+	v.VaultNamespace = registerRequest.Spec.VaultNamespace
+	err = r.configureVaultTLS(ctx, registerRequest, v)
+	if err != nil {
+		return v, err
+	}
+	// the mount recorded in the status was configured by the operator, possibly by a
+	// version which did not mark its mounts, so it is the only unmarked one adopted
+	v.Mount = registerStatus.VaultAuthMount
+	v.AdoptUnmarked = len(v.Mount) != 0
+	if !v.AdoptUnmarked {
+		v.Mount, err = r.desiredMountPath(ctx, registerRequest)
 		if err != nil {
 			return v, err
 		}
-		v.K8SHost = fmt.Sprintf("https://%s:6443", masterNode)
 	}
-	v.SAName = registerRequest.Spec.ServiceAccount
-	v.Namespace = registerRequest.Spec.Namespace
-	v.Policy = registerRequest.Spec.VaultPolicy
-	v.VaultToken = registerRequest.Annotations["token"]
-	v.VaultAddress = registerRequest.Spec.VaultAddr
-	v.RoleName = registerRequest.Spec.RoleName
-	if mount, ok := registerRequest.Annotations["mountPath"]; !ok {
-		v.Mount = "k8s" + generateRandomString(10)
-	} else {
-		v.Mount = mount
-	}
-	// Add to annotation. Will be needed for helm chart
-	registerRequest.Annotations["mountPath"] = v.Mount
+	v.Owner, err = r.mountOwner(ctx, registerRequest)
 	return v, err
 }
 
+// desiredRoles returns the primary role followed by the additional roles from the spec
+func desiredRoles(registerRequest *vaultv1alpha1.Register) (roles []vault.Role, err error) {
+	roles = append(roles, vault.Role{
+		Name:            registerRequest.Spec.RoleName,
+		ServiceAccounts: []string{registerRequest.Spec.ServiceAccount},
+		Namespaces:      []string{registerRequest.Spec.Namespace},
+		Policies:        registerRequest.Spec.VaultPolicy,
+		Options:         roleOptions(registerRequest.Spec.Role),
+	})
+
+	names := map[string]bool{registerRequest.Spec.RoleName: true}
+	for _, role := range registerRequest.Spec.Roles {
+		if names[role.Name] {
+			return roles, permanentf("duplicate vault role %s", role.Name)
+		}
+		names[role.Name] = true
+		roleSpec := role.RoleSpec
+		roles = append(roles, vault.Role{
+			Name:            role.Name,
+			ServiceAccounts: role.ServiceAccounts,
+			Namespaces:      role.Namespaces,
+			Policies:        role.Policies,
+			Options:         roleOptions(&roleSpec),
+		})
+	}
+	return roles, nil
+}
+
+// syncRoles writes every desired role and removes roles which were dropped from the spec
+func (r *RegisterReconciler) syncRoles(v *vault.VaultRegister, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	var roleStatuses []vaultv1alpha1.RoleStatus
+	desired := make(map[string]bool)
+	for _, role := range v.Roles {
+		desired[role.Name] = true
+		roleStatus := vaultv1alpha1.RoleStatus{Name: role.Name, Status: "Configured"}
+		if roleErr := v.WriteRole(role); roleErr != nil {
+			roleStatus.Status = "Failed"
+			roleStatus.Message = roleErr.Error()
+			err = roleErr
+			r.Recorder.Eventf(registerRequest, v1.EventTypeWarning, "RoleWriteFailed",
+				"unable to write role %s: %v", role.Name, roleErr)
+		} else {
+			r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "RoleWritten",
+				"role %s written to auth/%s", role.Name, v.Mount)
+		}
+		roleStatuses = append(roleStatuses, roleStatus)
+	}
+
+	for _, previous := range registerStatus.Roles {
+		if desired[previous.Name] {
+			continue
+		}
+		// keep tracking the role until it is actually gone from vault
+		if roleErr := v.DeleteRole(previous.Name); roleErr != nil {
+			roleStatuses = append(roleStatuses, vaultv1alpha1.RoleStatus{
+				Name:    previous.Name,
+				Status:  "DeleteFailed",
+				Message: roleErr.Error(),
+			})
+			err = roleErr
+			r.Recorder.Eventf(registerRequest, v1.EventTypeWarning, "RoleDeleteFailed",
+				"unable to delete role %s: %v", previous.Name, roleErr)
+		} else {
+			r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "RoleDeleted",
+				"role %s removed from auth/%s", previous.Name, v.Mount)
+		}
+	}
+
+	registerStatus.Roles = roleStatuses
+	return err
+}
+
+func roleOptions(role *vaultv1alpha1.RoleSpec) (options vault.RoleOptions) {
+	if role == nil {
+		return options
+	}
+	options = vault.RoleOptions{
+		TokenTTL:        role.TokenTTL,
+		TokenMaxTTL:     role.TokenMaxTTL,
+		TokenPeriod:     role.TokenPeriod,
+		TokenBoundCIDRs: role.TokenBoundCIDRs,
+		TokenNumUses:    role.TokenNumUses,
+		TokenType:       role.TokenType,
+		Audience:        role.Audience,
+		AliasNameSource: role.AliasNameSource,
+	}
+	return options
+}
+
 func (r *RegisterReconciler) findMasterNodes(ctx context.Context) (masterNode string, err error) {
 	nodeList := &v1.NodeList{}
 	err = r.List(ctx, nodeList)
@@ -305,74 +472,115 @@ func (r *RegisterReconciler) findMasterNodes(ctx context.Context) (masterNode st
 		return masterNode, err
 	}
 
+	// the first control plane node by name keeps the result stable in HA setups
+	sort.Slice(nodeList.Items, func(i, j int) bool {
+		return nodeList.Items[i].Name < nodeList.Items[j].Name
+	})
 	for _, node := range nodeList.Items {
 		if isMaster(node.GetLabels()) {
-			masterNode = getAddress(node)
+			if masterNode = getAddress(node); len(masterNode) != 0 {
+				return masterNode, nil
+			}
 		}
 	}
 	return masterNode, err
 }
 
-func (r *RegisterReconciler) installChart(ctx context.Context,
-	registerRequest *vaultv1alpha1.Register) (output []byte, err error) {
-	var vaultCertPresent bool
-	if len(registerRequest.Spec.VaultCACert) != 0 {
-		vaultCertPresent = true
-	}
-
-	helmWrapper := prepareHelmWrapper(registerRequest, vaultCertPresent)
-	if vaultCertPresent {
+// installChart creates the CA secret mounted by the chart and installs or upgrades it
+func (r *RegisterReconciler) installChart(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	helmWrapper helm.Wrapper, ca string) (rel *release.Release, err error) {
+	if len(ca) != 0 {
 		// need to create the secret with the ca cert chain
-		err = r.createCASecret(ctx, registerRequest)
+		err = r.createCASecret(ctx, registerRequest, ca)
 		if err != nil {
-			return output, err
+			return rel, err
 		}
 	}
 
-	output, err = helmWrapper.InstallChart()
-	return output, err
+	return helmWrapper.InstallChart(r.Helm)
 }
 
+// uninstallChart removes the chart of the backend recorded when it was installed. The chart
+// of a shared backend is kept until the last Register using it lets go
 func (r *RegisterReconciler) uninstallChart(ctx context.Context,
-	registerRequest *vaultv1alpha1.Register) (output []byte, err error) {
-	helmWrapper := prepareHelmWrapper(registerRequest, false)
-	output, err = helmWrapper.UninstallChart()
-	return output, err
+	registerRequest *vaultv1alpha1.Register, namespace string, backendName string) (err error) {
+	backend, err := secretsBackend(backendName)
+	if err != nil {
+		return err
+	}
+	if backend.Shared() {
+		inUse, err := r.sharedChartInUse(ctx, registerRequest, backend)
+		if err != nil || inUse {
+			return err
+		}
+	}
+	helmWrapper := helm.Wrapper{Namespace: namespace, Backend: backend, SharedNamespace: operatorNamespace()}
+	err = helmWrapper.UninstallChart(r.Helm)
+	if err != nil {
+		r.Recorder.Eventf(registerRequest, v1.EventTypeWarning, "ChartUninstallFailed",
+			"unable to uninstall chart from namespace %s: %v", helmWrapper.ReleaseNamespace(), err)
+	} else {
+		r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "ChartUninstalled",
+			"chart uninstalled from namespace %s", helmWrapper.ReleaseNamespace())
+	}
+	return err
 }
 
-func prepareHelmWrapper(registerRequest *vaultv1alpha1.Register, vaultCertPresent bool) (helmWrapper helm.Wrapper) {
+func prepareHelmWrapper(registerRequest *vaultv1alpha1.Register, vaultCertPresent bool) (helmWrapper helm.Wrapper, err error) {
+	backend, err := secretsBackend(registerRequest.Spec.SecretsBackend)
+	if err != nil {
+		return helmWrapper, err
+	}
 
 	helmWrapper = helm.Wrapper{
-		Namespace:       registerRequest.Spec.Namespace,
-		ServiceAccount:  registerRequest.Spec.ServiceAccount,
-		VaultAddress:    registerRequest.Spec.VaultAddr,
-		VaultSkipVerify: registerRequest.Spec.SSLDisable,
-		VaultCACert:     vaultCertPresent,
-		MountName:       registerRequest.Status.VaultAuthMount,
-		RoleName:        registerRequest.Spec.RoleName,
-	}
-	return helmWrapper
+		Namespace:         registerRequest.Spec.Namespace,
+		ServiceAccount:    registerRequest.Spec.ServiceAccount,
+		VaultAddress:      registerRequest.Spec.VaultAddr,
+		VaultNamespace:    registerRequest.Spec.VaultNamespace,
+		VaultSkipVerify:   registerRequest.Spec.SSLDisable,
+		VaultCACert:       vaultCertPresent,
+		MountName:         registerRequest.Status.VaultAuthMount,
+		RoleName:          registerRequest.Spec.RoleName,
+		Backend:           backend,
+		SecretStore:       secretStoreOptions(registerRequest),
+		OverrideProtected: registerRequest.Spec.OverrideProtectedValues,
+		SharedNamespace:   operatorNamespace(),
+		SecretProviderClass: helm.SecretProviderClassOptions{
+			Name: registerRequest.Name,
+		},
+	}
+	if class := registerRequest.Spec.SecretProviderClass; class != nil {
+		if len(class.Name) != 0 {
+			helmWrapper.SecretProviderClass.Name = class.Name
+		}
+		helmWrapper.SecretProviderClass.Objects = class.Objects
+	}
+	return helmWrapper, nil
 }
 
-func (r *RegisterReconciler) createCASecret(ctx context.Context, registerRequest *vaultv1alpha1.Register) (err error) {
-	stringData := make(map[string]string)
-	stringData["ca.pem"] = registerRequest.Spec.VaultCACert
+func (r *RegisterReconciler) createCASecret(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	ca string) (err error) {
 	secret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "vault-ca",
 			Namespace: registerRequest.Spec.Namespace,
 		},
-		StringData: stringData,
 	}
 
-	err = r.Create(ctx, secret)
+	// the chart may be upgraded with a new CA so the secret is kept up to date
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Data = map[string][]byte{"ca.pem": []byte(ca)}
+		return nil
+	})
 	return err
 }
 
 func isMaster(labels map[string]string) (ok bool) {
 	for key, value := range labels {
-		if strings.Contains(key, "controlplane") || strings.Contains(key, "master") {
-			if value == "true" {
+		if strings.Contains(key, "controlplane") || strings.Contains(key, "control-plane") ||
+			strings.Contains(key, "master") {
+			// kubeadm sets node-role labels without a value
+			if value == "true" || (strings.HasPrefix(key, "node-role.kubernetes.io/") && value == "") {
 				ok = true
 			}
 		}
@@ -400,16 +608,6 @@ func getAddress(node v1.Node) (address string) {
 	return address
 }
 
-func generateRandomString(size int) (random string) {
-	var letters = []rune("abcdefghijklmnopqrstuvwxyz")
-	b := make([]rune, size)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
-	}
-	random = string(b)
-	return random
-}
-
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
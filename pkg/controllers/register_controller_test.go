@@ -0,0 +1,81 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+)
+
+// registerEvents returns "<type>/<reason>" for every event recorded against the register
+func registerEvents(register *vaultv1alpha1.Register) func() []string {
+	return func() (events []string) {
+		eventList := &v1.EventList{}
+		if err := k8sClient.List(context.Background(), eventList, client.InNamespace(register.Namespace)); err != nil {
+			return nil
+		}
+		for _, event := range eventList.Items {
+			if event.InvolvedObject.Kind == "Register" && event.InvolvedObject.Name == register.Name {
+				events = append(events, event.Type+"/"+event.Reason)
+			}
+		}
+		return events
+	}
+}
+
+var _ = Describe("Register events", func() {
+	const timeout = 30 * time.Second
+
+	It("records the progress and failures of each step", func() {
+		ctx := context.Background()
+		register := &vaultv1alpha1.Register{
+			ObjectMeta: metav1.ObjectMeta{Name: "events", Namespace: "default"},
+			Spec: vaultv1alpha1.RegisterSpec{
+				VaultAddr:      "http://127.0.0.1:1",
+				ServiceAccount: "vault-auth",
+				Namespace:      "vault-glue-events",
+				VaultPolicy:    []string{"read"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, register)).To(Succeed())
+
+		By("failing without operator vault credentials")
+		Eventually(registerEvents(register), timeout).Should(ContainElement("Warning/TokenUnavailable"))
+
+		By("progressing once the credentials exist")
+		operatorNamespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: DefaultNamespace}}
+		Expect(k8sClient.Create(ctx, operatorNamespace)).To(Succeed())
+		token := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultSecret, Namespace: DefaultNamespace},
+			StringData: map[string]string{tokenKey: "root"},
+		}
+		Expect(k8sClient.Create(ctx, token)).To(Succeed())
+
+		Eventually(registerEvents(register), timeout).Should(ContainElement("Normal/" + vaultv1alpha1.ConditionTokenAvailable))
+		Eventually(registerEvents(register), timeout).Should(ContainElement("Normal/" + vaultv1alpha1.ConditionServiceAccountReady))
+		// there is no vault listening so configuring the auth mount must fail
+		Eventually(registerEvents(register), timeout).Should(ContainElement("Warning/VaultAuthFailed"))
+	})
+})
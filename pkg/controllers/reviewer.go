@@ -0,0 +1,219 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	authDelegatorRole = "system:auth-delegator"
+	rootCAConfigMap   = "kube-root-ca.crt"
+)
+
+// reviewerServiceAccountName is the service account in the operator namespace whose JWT
+// vault uses to review logins. It is kept apart from the workload service accounts
+func reviewerServiceAccountName(registerRequest *vaultv1alpha1.Register) string {
+	if omitReviewerJWT(registerRequest) {
+		return ""
+	}
+	if reviewer := registerRequest.Spec.TokenReviewer; reviewer != nil && len(reviewer.ServiceAccount) != 0 {
+		return reviewer.ServiceAccount
+	}
+	return fmt.Sprintf("%s-%s-vault-reviewer", registerRequest.Namespace, registerRequest.Name)
+}
+
+func omitReviewerJWT(registerRequest *vaultv1alpha1.Register) bool {
+	return registerRequest.Spec.TokenReviewer != nil && registerRequest.Spec.TokenReviewer.OmitJWT
+}
+
+// reviewerBindingName includes the operator namespace as the binding is cluster scoped
+func reviewerBindingName(serviceAccount string) string {
+	return fmt.Sprintf("vault-glue-operator:%s:%s", operatorNamespace(), serviceAccount)
+}
+
+// ownedBy reports whether an object was created by the operator for this Register
+func ownedBy(registerRequest *vaultv1alpha1.Register, labels map[string]string) bool {
+	uid, ok := labels[registerUIDLabel]
+	return ok && len(uid) != 0 && uid == string(registerRequest.UID)
+}
+
+// reconcileReviewer creates the reviewer service account and binds it to system:auth-delegator
+// so vault can call the TokenReview API with its JWT. Existing service accounts and bindings
+// are never adopted, their tokens would be handed to the vault of the Register
+func (r *RegisterReconciler) reconcileReviewer(ctx context.Context, registerRequest *vaultv1alpha1.Register) (err error) {
+	name := reviewerServiceAccountName(registerRequest)
+	if len(name) == 0 {
+		return nil
+	}
+
+	sa := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: operatorNamespace()}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, sa, func() error {
+		if len(sa.ResourceVersion) == 0 {
+			sa.Labels = map[string]string{registerUIDLabel: string(registerRequest.UID)}
+		} else if !ownedBy(registerRequest, sa.Labels) {
+			return permanentf("reviewer service account %s/%s was not created for this Register", sa.Namespace, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: reviewerBindingName(name)}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, binding, func() error {
+		if len(binding.ResourceVersion) == 0 {
+			binding.Labels = map[string]string{registerUIDLabel: string(registerRequest.UID)}
+		} else if !ownedBy(registerRequest, binding.Labels) {
+			return permanentf("reviewer binding %s was not created for this Register", binding.Name)
+		}
+		binding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     authDelegatorRole,
+		}
+		binding.Subjects = []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      sa.Name,
+			Namespace: sa.Namespace,
+		}}
+		return nil
+	})
+	return err
+}
+
+// cleanupReviewer removes the binding and the reviewer service account when they were
+// created for this Register. The token secret is garbage collected with the service account
+func (r *RegisterReconciler) cleanupReviewer(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	name string) (err error) {
+	if len(name) == 0 {
+		return nil
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{}
+	err = r.Get(ctx, types.NamespacedName{Name: reviewerBindingName(name)}, binding)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && ownedBy(registerRequest, binding.Labels) {
+		if err = client.IgnoreNotFound(r.Delete(ctx, binding)); err != nil {
+			return err
+		}
+	}
+
+	sa := &v1.ServiceAccount{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(), Name: name}, sa)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !ownedBy(registerRequest, sa.Labels) {
+		return nil
+	}
+	return client.IgnoreNotFound(r.Delete(ctx, sa))
+}
+
+// reviewerCredential is the reviewer JWT and cluster CA written to the vault auth config
+type reviewerCredential struct {
+	Token  string
+	CACert string
+	// Expiry of a short lived token as returned by the TokenRequest API
+	Expiry time.Time
+}
+
+// reviewerCredentials returns the reviewer JWT and the cluster CA. The CA is read from the
+// kube-root-ca.crt ConfigMap, which follows CA rotation, and falls back to the token secret
+// on clusters without it. Short lived tokens are only requested when mint is set
+func (r *RegisterReconciler) reviewerCredentials(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	mint bool) (credential reviewerCredential, err error) {
+	configMap := &v1.ConfigMap{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(), Name: rootCAConfigMap}, configMap)
+	if err != nil && !errors.IsNotFound(err) {
+		return credential, err
+	}
+	credential.CACert = configMap.Data[caKey]
+
+	name := reviewerServiceAccountName(registerRequest)
+	if len(name) == 0 {
+		if len(credential.CACert) == 0 {
+			return credential, fmt.Errorf("cluster CA not found in ConfigMap %s/%s", operatorNamespace(), rootCAConfigMap)
+		}
+		return credential, nil
+	}
+
+	sa := &v1.ServiceAccount{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(), Name: name}, sa)
+	if err != nil {
+		return credential, err
+	}
+	// only tokens of the service account created for this Register are sent to its vault
+	if !ownedBy(registerRequest, sa.Labels) {
+		return credential, permanentf("reviewer service account %s/%s was not created for this Register",
+			sa.Namespace, name)
+	}
+	if tokenExpiration(registerRequest) != 0 {
+		if err = validTokenLifetime(registerRequest); err != nil {
+			return credential, err
+		}
+		if len(credential.CACert) == 0 {
+			return credential, fmt.Errorf("cluster CA not found in ConfigMap %s/%s", operatorNamespace(), rootCAConfigMap)
+		}
+		if mint {
+			credential.Token, credential.Expiry, err = r.requestToken(registerRequest, sa)
+		}
+		return credential, err
+	}
+
+	token, caCert, err := r.reviewerToken(ctx, registerRequest, sa)
+	credential.Token = token
+	if len(credential.CACert) == 0 {
+		credential.CACert = caCert
+	}
+	return credential, err
+}
+
+// requestToken creates a short lived reviewer token with the TokenRequest API. The api
+// server may shorten the lifetime, so the expiry it returns is used
+func (r *RegisterReconciler) requestToken(registerRequest *vaultv1alpha1.Register,
+	sa *v1.ServiceAccount) (token string, expiry time.Time, err error) {
+	if r.KubeClient == nil {
+		return token, expiry, fmt.Errorf("no kubernetes clientset configured for token requests")
+	}
+	expirationSeconds := int64(tokenExpiration(registerRequest).Seconds())
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expirationSeconds},
+	}
+	tokenRequest, err = r.KubeClient.CoreV1().ServiceAccounts(sa.Namespace).CreateToken(sa.Name, tokenRequest)
+	if err != nil {
+		return token, expiry, err
+	}
+	if tokenRequest.Status.ExpirationTimestamp.IsZero() {
+		return token, expiry, fmt.Errorf("token request for %s/%s returned no expiration", sa.Namespace, sa.Name)
+	}
+	return tokenRequest.Status.Token, tokenRequest.Status.ExpirationTimestamp.Time, nil
+}
@@ -0,0 +1,129 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcileReviewer(t *testing.T) {
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{
+		Name: "demo", Namespace: "default", UID: "register-uid"}}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Scheme: scheme.Scheme}
+
+	name := reviewerServiceAccountName(registerRequest)
+	if name != "default-demo-vault-reviewer" {
+		t.Fatalf("unexpected reviewer name %s", name)
+	}
+	if err := r.reconcileReviewer(ctx, registerRequest); err != nil {
+		t.Fatal(err)
+	}
+
+	sa := &v1.ServiceAccount{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(), Name: name}, sa); err != nil {
+		t.Fatalf("reviewer service account not created: %v", err)
+	}
+	binding := &rbacv1.ClusterRoleBinding{}
+	if err := r.Get(ctx, types.NamespacedName{Name: reviewerBindingName(name)}, binding); err != nil {
+		t.Fatalf("reviewer binding not created: %v", err)
+	}
+	if binding.RoleRef.Name != authDelegatorRole || len(binding.Subjects) != 1 ||
+		binding.Subjects[0].Name != name || binding.Subjects[0].Namespace != operatorNamespace() {
+		t.Fatalf("unexpected binding %+v", binding)
+	}
+
+	if err := r.cleanupReviewer(ctx, registerRequest, name); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Name: reviewerBindingName(name)}, binding); !errors.IsNotFound(err) {
+		t.Fatalf("reviewer binding not removed: %v", err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(), Name: name}, sa); !errors.IsNotFound(err) {
+		t.Fatalf("reviewer service account not removed: %v", err)
+	}
+}
+
+func TestReviewerCredentialsWithoutJWT(t *testing.T) {
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec:       vaultv1alpha1.RegisterSpec{TokenReviewer: &vaultv1alpha1.TokenReviewerSpec{OmitJWT: true}},
+	}
+	rootCA := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: rootCAConfigMap, Namespace: operatorNamespace()},
+		Data:       map[string]string{caKey: "ca"},
+	}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, rootCA), Scheme: scheme.Scheme}
+
+	if err := r.reconcileReviewer(ctx, registerRequest); err != nil {
+		t.Fatal(err)
+	}
+	credential, err := r.reviewerCredentials(ctx, registerRequest, true)
+	if err != nil || len(credential.Token) != 0 || credential.CACert != "ca" {
+		t.Fatalf("reviewerCredentials() = %+v, %v", credential, err)
+	}
+}
+
+func TestReconcileReviewerRefusesExistingServiceAccounts(t *testing.T) {
+	ctx := context.Background()
+	// objects read from the api server always carry a resource version
+	operator := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "vault-glue-operator",
+		Namespace: operatorNamespace(), ResourceVersion: "1"}}
+	first := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default",
+		UID: "first-uid"}, Spec: vaultv1alpha1.RegisterSpec{TokenReviewer: &vaultv1alpha1.TokenReviewerSpec{
+		ServiceAccount: "shared-reviewer"}}}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, operator), Scheme: scheme.Scheme}
+
+	// the service account of the operator is never adopted, nor its token handed out
+	registerRequest := first.DeepCopy()
+	registerRequest.Spec.TokenReviewer.ServiceAccount = operator.Name
+	if err := r.reconcileReviewer(ctx, registerRequest); !isPermanent(err) {
+		t.Fatalf("expected the operator service account to be refused, got %v", err)
+	}
+	if _, err := r.reviewerCredentials(ctx, registerRequest, true); !isPermanent(err) {
+		t.Fatalf("expected no token for the operator service account, got %v", err)
+	}
+
+	if err := r.reconcileReviewer(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second := first.DeepCopy()
+	second.Name = "second"
+	second.UID = "second-uid"
+	if err := r.reconcileReviewer(ctx, second); !isPermanent(err) {
+		t.Fatalf("expected a reviewer of another Register to be refused, got %v", err)
+	}
+	if err := r.cleanupReviewer(ctx, second, "shared-reviewer"); err != nil {
+		t.Fatal(err)
+	}
+	binding := &rbacv1.ClusterRoleBinding{}
+	if err := r.Get(ctx, types.NamespacedName{Name: reviewerBindingName("shared-reviewer")}, binding); err != nil ||
+		!ownedBy(first, binding.Labels) {
+		t.Fatalf("binding of the first Register removed or taken over: %v %v", binding.Labels, err)
+	}
+}
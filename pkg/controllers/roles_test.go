@@ -0,0 +1,141 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// roleVault keeps the roles written to auth/k8s-demo and refuses to delete the roles in refused
+type roleVault struct {
+	sync.Mutex
+	roles   map[string]map[string]interface{}
+	deleted []string
+	refused map[string]bool
+}
+
+func (rv *roleVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rv.Lock()
+	defer rv.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	name := strings.TrimPrefix(req.URL.Path, "/v1/auth/k8s-demo/role/")
+	if name == req.URL.Path {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case http.MethodPut:
+		data := map[string]interface{}{}
+		_ = json.NewDecoder(req.Body).Decode(&data)
+		rv.roles[name] = data
+	case http.MethodDelete:
+		if rv.refused[name] {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		rv.deleted = append(rv.deleted, name)
+		delete(rv.roles, name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestSyncRoles(t *testing.T) {
+	rv := &roleVault{roles: map[string]map[string]interface{}{}, refused: map[string]bool{"stuck": true}}
+	server := httptest.NewServer(rv)
+	defer server.Close()
+
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: vaultv1alpha1.RegisterSpec{
+			ServiceAccount: "vault-auth",
+			Namespace:      "apps",
+			VaultPolicy:    []string{"read"},
+			Roles: []vaultv1alpha1.VaultRoleSpec{{
+				Name:            "ci",
+				ServiceAccounts: []string{"runner", "builder"},
+				Namespaces:      []string{"ci"},
+				Policies:        []string{"deploy"},
+			}},
+		},
+	}
+	registerRequest.SetDefaults()
+	roles, err := desiredRoles(registerRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &vault.VaultRegister{VaultAddress: server.URL, Auth: &vault.TokenAuth{Token: "root"},
+		Mount: "k8s-demo", Roles: roles}
+	r := &RegisterReconciler{Recorder: record.NewFakeRecorder(100)}
+
+	// roles dropped from the spec since the last sync are removed from vault
+	registerStatus := &vaultv1alpha1.RegisterStatus{Roles: []vaultv1alpha1.RoleStatus{
+		{Name: registerRequest.Spec.RoleName, Status: "Configured"},
+		{Name: "old", Status: "Configured"},
+	}}
+	if err := r.syncRoles(v, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rv.deleted, []string{"old"}) {
+		t.Fatalf("deleted roles %v, want [old]", rv.deleted)
+	}
+
+	// every role is written with its own bindings
+	want := map[string][]interface{}{
+		registerRequest.Spec.RoleName: {[]interface{}{"vault-auth"}, []interface{}{"apps"}, []interface{}{"read"}},
+		"ci":                          {[]interface{}{"runner", "builder"}, []interface{}{"ci"}, []interface{}{"deploy"}},
+	}
+	if len(rv.roles) != len(want) {
+		t.Fatalf("roles in vault %v, want %v", rv.roles, want)
+	}
+	for name, bindings := range want {
+		written := rv.roles[name]
+		got := []interface{}{written["bound_service_account_names"], written["bound_service_account_namespaces"],
+			written["policies"]}
+		if !reflect.DeepEqual(got, bindings) {
+			t.Errorf("role %s bound to %v, want %v", name, got, bindings)
+		}
+	}
+	wantStatus := []vaultv1alpha1.RoleStatus{
+		{Name: registerRequest.Spec.RoleName, Status: "Configured"},
+		{Name: "ci", Status: "Configured"},
+	}
+	if !reflect.DeepEqual(registerStatus.Roles, wantStatus) {
+		t.Fatalf("role status %v, want %v", registerStatus.Roles, wantStatus)
+	}
+
+	// a role vault refuses to delete stays tracked so the next sync removes it
+	registerStatus.Roles = append(registerStatus.Roles, vaultv1alpha1.RoleStatus{Name: "stuck", Status: "Configured"})
+	if err := r.syncRoles(v, registerRequest, registerStatus); err == nil {
+		t.Fatalf("expected the refused delete to fail")
+	}
+	last := registerStatus.Roles[len(registerStatus.Roles)-1]
+	if len(registerStatus.Roles) != 3 || last.Name != "stuck" || last.Status != "DeleteFailed" {
+		t.Fatalf("refused role not tracked: %v", registerStatus.Roles)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// tokenExpiration is the lifetime of short lived reviewer tokens, zero when a token secret is used
+func tokenExpiration(registerRequest *vaultv1alpha1.Register) time.Duration {
+	reviewer := registerRequest.Spec.TokenReviewer
+	if reviewer == nil || reviewer.OmitJWT || reviewer.TokenExpiration == nil {
+		return 0
+	}
+	return reviewer.TokenExpiration.Duration
+}
+
+// validTokenLifetime refuses reviewer token lifetimes the webhook rejects, for clusters
+// running without it
+func validTokenLifetime(registerRequest *vaultv1alpha1.Register) (err error) {
+	expiration := tokenExpiration(registerRequest)
+	if expiration < vaultv1alpha1.MinTokenExpiration {
+		return permanentf("tokenReviewer.tokenExpiration must be at least %v", vaultv1alpha1.MinTokenExpiration)
+	}
+	if before := renewBefore(registerRequest); before <= 0 || before >= expiration {
+		return permanentf("tokenReviewer.renewBefore must be positive and shorter than tokenExpiration")
+	}
+	return nil
+}
+
+func renewBefore(registerRequest *vaultv1alpha1.Register) time.Duration {
+	if reviewer := registerRequest.Spec.TokenReviewer; reviewer != nil && reviewer.RenewBefore != nil {
+		return reviewer.RenewBefore.Duration
+	}
+	return tokenExpiration(registerRequest) / 5
+}
+
+// reviewerChecksum identifies the credential written to vault. Short lived tokens differ on
+// every request and are renewed on schedule instead, so only their CA is compared
+func reviewerChecksum(registerRequest *vaultv1alpha1.Register, credential reviewerCredential) string {
+	token := credential.Token
+	if tokenExpiration(registerRequest) != 0 {
+		token = ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token+"\n"+credential.CACert)))
+}
+
+// recordAuthConfig remembers the host and credential written with the auth config of v
+func recordAuthConfig(registerRequest *vaultv1alpha1.Register, registerStatus *vaultv1alpha1.RegisterStatus,
+	v *vault.VaultRegister) {
+	applied := appliedSpec(registerStatus)
+	applied.K8SHost = v.K8SHost
+	applied.K8SEndpointStrategy = endpointStrategy(registerRequest)
+	applied.ReviewerChecksum = reviewerChecksum(registerRequest,
+		reviewerCredential{Token: v.SAToken, CACert: v.K8SCACert})
+	applied.ReviewerTokenExpiry = nil
+	if tokenExpiration(registerRequest) != 0 && !v.SATokenExpiry.IsZero() {
+		expiry := metav1.NewTime(v.SATokenExpiry)
+		applied.ReviewerTokenExpiry = &expiry
+	}
+}
+
+// nextReviewerRenewal returns how long until the short lived reviewer token must be renewed
+func nextReviewerRenewal(registerRequest *vaultv1alpha1.Register, registerStatus *vaultv1alpha1.RegisterStatus) (due time.Duration) {
+	if tokenExpiration(registerRequest) == 0 {
+		return 0
+	}
+	expiry := appliedSpec(registerStatus).ReviewerTokenExpiry
+	if expiry == nil {
+		return 0
+	}
+	return time.Until(expiry.Add(-renewBefore(registerRequest)))
+}
+
+// rotateReviewer re-writes the vault auth config when the reviewer token secret or the
+// cluster CA changed, or the short lived reviewer token is due for renewal
+func (r *RegisterReconciler) rotateReviewer(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	credential, err := r.reviewerCredentials(ctx, registerRequest, false)
+	if err != nil {
+		return err
+	}
+	changed := reviewerChecksum(registerRequest, credential) != appliedSpec(registerStatus).ReviewerChecksum
+	renew := tokenExpiration(registerRequest) != 0 && nextReviewerRenewal(registerRequest, registerStatus) <= 0
+	if !changed && !renew {
+		return nil
+	}
+
+	v, err := r.prepareVaultRequest(ctx, registerRequest, registerStatus)
+	if err == nil {
+		_, err = v.RegisterCluster()
+	}
+	if err != nil {
+		r.Recorder.Event(registerRequest, v1.EventTypeWarning, "ReviewerRotationFailed", err.Error())
+		return err
+	}
+	recordAuthConfig(registerRequest, registerStatus, v)
+	r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "ReviewerRotated",
+		"token reviewer credentials re-written to auth/%s/config", v.Mount)
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+	return nil
+}
+
+// registersForReviewerSecret maps a reviewer token secret to the Registers using it
+func (r *RegisterReconciler) registersForReviewerSecret(object handler.MapObject) []reconcile.Request {
+	serviceAccount := object.Meta.GetAnnotations()[v1.ServiceAccountNameKey]
+	if object.Meta.GetNamespace() != operatorNamespace() || len(serviceAccount) == 0 ||
+		object.Meta.GetName() != reviewerTokenSecretName(serviceAccount) {
+		return nil
+	}
+	return r.registerRequests(func(registerRequest *vaultv1alpha1.Register) bool {
+		return reviewerServiceAccountName(registerRequest) == serviceAccount
+	})
+}
+
+// registersForRootCA maps the cluster CA ConfigMap to every Register
+func (r *RegisterReconciler) registersForRootCA(object handler.MapObject) []reconcile.Request {
+	if object.Meta.GetNamespace() != operatorNamespace() || object.Meta.GetName() != rootCAConfigMap {
+		return nil
+	}
+	return r.registerRequests(func(registerRequest *vaultv1alpha1.Register) bool {
+		return true
+	})
+}
+
+func (r *RegisterReconciler) registerRequests(match func(registerRequest *vaultv1alpha1.Register) bool) (requests []reconcile.Request) {
+	registers := &vaultv1alpha1.RegisterList{}
+	if err := r.List(context.Background(), registers); err != nil {
+		r.Log.Error(err, "unable to list Registers")
+		return nil
+	}
+	for i := range registers.Items {
+		registers.Items[i].SetDefaults()
+		if match(&registers.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: registers.Items[i].Namespace,
+				Name:      registers.Items[i].Name,
+			}})
+		}
+	}
+	return requests
+}
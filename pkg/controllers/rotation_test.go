@@ -0,0 +1,143 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestShortLivedReviewerToken(t *testing.T) {
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "register-uid"},
+		Spec: vaultv1alpha1.RegisterSpec{TokenReviewer: &vaultv1alpha1.TokenReviewerSpec{
+			TokenExpiration: &metav1.Duration{Duration: time.Hour},
+		}},
+	}
+	reviewer := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+		Name: reviewerServiceAccountName(registerRequest), Namespace: operatorNamespace(),
+		Labels: map[string]string{registerUIDLabel: "register-uid"}}}
+	rootCA := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: rootCAConfigMap, Namespace: operatorNamespace()},
+		Data:       map[string]string{caKey: "ca"},
+	}
+	kubeClient := kubefake.NewSimpleClientset()
+	var requested int64
+	// the api server may hand out a token shorter lived than requested
+	expiry := metav1.NewTime(time.Now().Add(50 * time.Minute))
+	kubeClient.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tokenRequest := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		requested = *tokenRequest.Spec.ExpirationSeconds
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{
+			Token: "short", ExpirationTimestamp: expiry}}, nil
+	})
+	r := &RegisterReconciler{
+		Client:     fake.NewFakeClientWithScheme(scheme.Scheme, reviewer, rootCA),
+		Scheme:     scheme.Scheme,
+		KubeClient: kubeClient,
+	}
+
+	credential, err := r.reviewerCredentials(ctx, registerRequest, true)
+	if err != nil || credential.Token != "short" || credential.CACert != "ca" {
+		t.Fatalf("reviewerCredentials() = %+v, %v", credential, err)
+	}
+	if requested != 3600 {
+		t.Fatalf("requested expiration %d, want 3600", requested)
+	}
+
+	registerStatus := &vaultv1alpha1.RegisterStatus{}
+	if due := nextReviewerRenewal(registerRequest, registerStatus); due != 0 {
+		t.Fatalf("renewal due in %v before any token was written", due)
+	}
+	recordAuthConfig(registerRequest, registerStatus, &vault.VaultRegister{SAToken: "short", K8SCACert: "ca",
+		SATokenExpiry: credential.Expiry})
+	due := nextReviewerRenewal(registerRequest, registerStatus)
+	if due <= 37*time.Minute || due > 38*time.Minute {
+		t.Fatalf("renewal due in %v, want 38m", due)
+	}
+
+	// a new token on each request must not count as a rotation, a new CA does
+	unminted, err := r.reviewerCredentials(ctx, registerRequest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reviewerChecksum(registerRequest, unminted) != registerStatus.Applied.ReviewerChecksum {
+		t.Fatalf("short lived token counted as a rotation")
+	}
+	unminted.CACert = "rotated"
+	if reviewerChecksum(registerRequest, unminted) == registerStatus.Applied.ReviewerChecksum {
+		t.Fatalf("rotated CA not detected")
+	}
+
+	// lifetimes the TokenRequest API refuses or renewed too late are never requested
+	registerRequest.Spec.TokenReviewer.TokenExpiration.Duration = time.Minute
+	if _, err := r.reviewerCredentials(ctx, registerRequest, true); !isPermanent(err) {
+		t.Fatalf("expected a permanent error for a 1m token, got %v", err)
+	}
+	registerRequest.Spec.TokenReviewer.TokenExpiration.Duration = time.Hour
+	registerRequest.Spec.TokenReviewer.RenewBefore = &metav1.Duration{Duration: 2 * time.Hour}
+	if _, err := r.reviewerCredentials(ctx, registerRequest, true); !isPermanent(err) {
+		t.Fatalf("expected a permanent error when renewing after expiry, got %v", err)
+	}
+}
+
+func TestRegistersForReviewerSecret(t *testing.T) {
+	first := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default"}}
+	second := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "default"}}
+	registerScheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(registerScheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := vaultv1alpha1.AddToScheme(registerScheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(registerScheme, first, second)}
+
+	reviewer := reviewerServiceAccountName(first)
+	secret := &v1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Name:        reviewerTokenSecretName(reviewer),
+		Namespace:   operatorNamespace(),
+		Annotations: map[string]string{v1.ServiceAccountNameKey: reviewer},
+	}}
+	requests := r.registersForReviewerSecret(handler.MapObject{Meta: secret, Object: secret})
+	if len(requests) != 1 || requests[0].Name != "first" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+
+	rootCA := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: rootCAConfigMap, Namespace: operatorNamespace()}}
+	if requests := r.registersForRootCA(handler.MapObject{Meta: rootCA, Object: rootCA}); len(requests) != 2 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	rootCA.Namespace = "default"
+	if requests := r.registersForRootCA(handler.MapObject{Meta: rootCA, Object: rootCA}); len(requests) != 0 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+}
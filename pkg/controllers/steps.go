@@ -0,0 +1,418 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// reconcileStep sets up one part of the integration and owns one condition
+type reconcileStep struct {
+	conditionType string
+	failureReason string
+	run           func(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+		registerStatus *vaultv1alpha1.RegisterStatus) (condition vaultv1alpha1.Condition, err error)
+}
+
+func (r *RegisterReconciler) steps() []reconcileStep {
+	return []reconcileStep{
+		{vaultv1alpha1.ConditionTokenAvailable, "TokenUnavailable", r.checkToken},
+		{vaultv1alpha1.ConditionServiceAccountReady, "ServiceAccountFailed", r.reconcileServiceAccount},
+		{vaultv1alpha1.ConditionVaultAuthConfigured, "VaultAuthFailed", r.reconcileVaultAuth},
+		{vaultv1alpha1.ConditionExternalSecretsInstalled, "ChartInstallFailed", r.reconcileChart},
+	}
+}
+
+// runSteps runs every step in order and stops at the first failure. The steps are
+// idempotent so they are simply re-run until the Register is ready for its generation
+func (r *RegisterReconciler) runSteps(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	previous := *appliedSpec(registerStatus)
+	for _, step := range r.steps() {
+		condition, err := step.run(ctx, registerRequest, registerStatus)
+		if err != nil {
+			reason := step.failureReason
+			if isMountNotOwned(err) {
+				reason = "MountNotOwned"
+			}
+			r.Recorder.Event(registerRequest, v1.EventTypeWarning, reason, err.Error())
+			setCondition(registerRequest, registerStatus, step.conditionType, metav1.ConditionFalse,
+				reason, err.Error())
+			setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+				reason, err.Error())
+			return err
+		}
+		if len(condition.Status) == 0 {
+			condition.Status = metav1.ConditionTrue
+		}
+		// steps are re-run until ready so only transitions are worth an event
+		if existing := registerStatus.GetCondition(step.conditionType); existing == nil ||
+			existing.Status != condition.Status || existing.ObservedGeneration != registerRequest.Generation {
+			r.Recorder.Event(registerRequest, v1.EventTypeNormal, step.conditionType, condition.Message)
+		}
+		setCondition(registerRequest, registerStatus, step.conditionType, condition.Status,
+			condition.Reason, condition.Message)
+	}
+
+	// the previous service account is only removed once nothing uses it any more
+	if err = r.cleanupServiceAccount(ctx, registerRequest, previous); err != nil {
+		r.Recorder.Event(registerRequest, v1.EventTypeWarning, "CleanupFailed", err.Error())
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+			"CleanupFailed", err.Error())
+		return err
+	}
+	if reviewer := reviewerServiceAccountName(registerRequest); previous.ReviewerServiceAccount != reviewer {
+		if err = r.cleanupReviewer(ctx, registerRequest, previous.ReviewerServiceAccount); err != nil {
+			r.Recorder.Event(registerRequest, v1.EventTypeWarning, "CleanupFailed", err.Error())
+			setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionFalse,
+				"CleanupFailed", err.Error())
+			return err
+		}
+	}
+	registerStatus.Applied.ReviewerServiceAccount = reviewerServiceAccountName(registerRequest)
+	registerStatus.Applied.ServiceAccount = registerRequest.Spec.ServiceAccount
+	registerStatus.Applied.Namespace = registerRequest.Spec.Namespace
+	registerStatus.ObservedGeneration = registerRequest.Generation
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionTrue,
+		"Processed", "")
+	return nil
+}
+
+// legacyProcessed is the status older versions recorded once every step had succeeded
+const legacyProcessed = "Processed"
+
+// migrateLegacyStatus carries a Register processed by an older version over to the conditions,
+// so its chart and auth mount are not set up again. The spec is taken as applied and the drift
+// check that follows repairs vault where it differs. Only the reviewer, which older versions
+// did not create, is set up here
+func (r *RegisterReconciler) migrateLegacyStatus(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	if registerStatus.LegacyStatus != legacyProcessed || len(registerStatus.Conditions) != 0 {
+		return nil
+	}
+	backend, err := secretsBackend(registerRequest.Spec.SecretsBackend)
+	if err != nil {
+		return err
+	}
+	if err = r.reconcileReviewer(ctx, registerRequest); err != nil {
+		return err
+	}
+
+	applied := appliedSpec(registerStatus)
+	applied.VaultAddr = registerRequest.Spec.VaultAddr
+	applied.ServiceAccount = registerRequest.Spec.ServiceAccount
+	applied.Namespace = registerRequest.Spec.Namespace
+	applied.ReviewerServiceAccount = reviewerServiceAccountName(registerRequest)
+	if registerStatus.HelmStatus == "Installed" {
+		applied.SecretsBackend = backend.Name()
+	}
+	for _, step := range r.steps() {
+		setCondition(registerRequest, registerStatus, step.conditionType, metav1.ConditionTrue,
+			"Migrated", "processed by an earlier version")
+	}
+	registerStatus.LegacyStatus = ""
+	registerStatus.ObservedGeneration = registerRequest.Generation
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionReady, metav1.ConditionTrue,
+		"Migrated", "")
+	r.Recorder.Event(registerRequest, v1.EventTypeNormal, "Migrated",
+		"status of an earlier version carried over to the conditions")
+	return nil
+}
+
+func (r *RegisterReconciler) checkToken(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (condition vaultv1alpha1.Condition, err error) {
+	//Lets check if the operator can authenticate to vault//
+	_, err = r.resolveAuthenticator(ctx, registerRequest)
+	condition.Reason = "CredentialsFound"
+	condition.Message = "operator vault credentials are available"
+	return condition, err
+}
+
+func (r *RegisterReconciler) reconcileServiceAccount(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (condition vaultv1alpha1.Condition, err error) {
+	err = r.createSA(ctx, registerRequest)
+	if err == nil {
+		err = r.reconcileReviewer(ctx, registerRequest)
+	}
+	condition.Reason = "Created"
+	condition.Message = fmt.Sprintf("service account %s/%s exists", registerRequest.Spec.Namespace,
+		registerRequest.Spec.ServiceAccount)
+	return condition, err
+}
+
+func (r *RegisterReconciler) reconcileVaultAuth(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (condition vaultv1alpha1.Condition, err error) {
+	applied := appliedSpec(registerStatus)
+	if len(applied.VaultAddr) != 0 && applied.VaultAddr != registerRequest.Spec.VaultAddr {
+		// the auth mount moves to the new vault. Cleaning up the old one is best effort
+		// as it may not be reachable any more
+		old, err := r.prepareVaultClient(ctx, registerRequest, registerStatus)
+		if err == nil {
+			old.VaultAddress = applied.VaultAddr
+			err = old.UnregisterCluster()
+		}
+		if err != nil {
+			r.Log.Error(err, "unable to clean up auth mount on previous vault", "vaultAddr", applied.VaultAddr)
+		}
+		registerStatus.Roles = nil
+		registerStatus.VaultAuthMount = ""
+		applied.VaultAddr = ""
+	}
+
+	mount, err := r.desiredMountPath(ctx, registerRequest)
+	if err != nil {
+		return condition, err
+	}
+	if current := registerStatus.VaultAuthMount; len(current) != 0 && current != mount {
+		// the mount path was changed in the spec, the old mount was enabled by this Register
+		old, err := r.prepareVaultClient(ctx, registerRequest, registerStatus)
+		if err == nil {
+			err = old.UnregisterCluster()
+		}
+		switch {
+		case isMountNotOwned(err):
+			r.Recorder.Event(registerRequest, v1.EventTypeWarning, "MountNotOwned", err.Error())
+		case err != nil:
+			return condition, err
+		default:
+			r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "VaultAuthDisabled",
+				"auth mount %s disabled, moving to %s", current, mount)
+		}
+		registerStatus.Roles = nil
+		registerStatus.VaultAuthMount = ""
+	}
+
+	v, err := r.prepareVaultRequest(ctx, registerRequest, registerStatus)
+	if err != nil {
+		return condition, err
+	}
+	enabled, err := v.RegisterCluster()
+	if enabled {
+		r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "VaultAuthEnabled",
+			"kubernetes auth enabled at %s", v.Mount)
+	}
+	if enabled || err == nil {
+		// recorded right away so the mount is cleaned up even if configuring it fails
+		registerStatus.VaultAuthMount = v.Mount
+	}
+	if err == nil {
+		recordAuthConfig(registerRequest, registerStatus, v)
+		// roles which were renamed or dropped are removed here
+		err = r.syncRoles(v, registerRequest, registerStatus)
+	}
+	if err != nil {
+		return condition, err
+	}
+	r.verifyLogin(ctx, registerRequest, registerStatus, v)
+
+	registerStatus.VaultAuthMount = v.Mount
+	applied.VaultAddr = registerRequest.Spec.VaultAddr
+	condition.Reason = "Configured"
+	condition.Message = fmt.Sprintf("auth mount %s configured with %d roles", v.Mount, len(v.Roles))
+	return condition, nil
+}
+
+// reconcileChart installs or upgrades the chart when its values change, moves it when the
+// namespace changes and removes it when the install is no longer wanted
+func (r *RegisterReconciler) reconcileChart(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (condition vaultv1alpha1.Condition, err error) {
+	applied := appliedSpec(registerStatus)
+	installed := registerStatus.HelmStatus == "Installed"
+	namespaceChanged := len(applied.Namespace) != 0 && applied.Namespace != registerRequest.Spec.Namespace
+	backend, err := secretsBackend(registerRequest.Spec.SecretsBackend)
+	if err != nil {
+		return condition, err
+	}
+	if backend.Shared() {
+		if err = sharedBackendSpec(registerRequest, backend); err != nil {
+			return condition, err
+		}
+		// the chart of a shared backend does not move with the namespace, its objects do
+		namespaceChanged = false
+	}
+	// the applied backend is empty for charts installed before backends were selectable
+	previous, _ := secretsBackend(applied.SecretsBackend)
+	backendChanged := previous == nil || previous.Name() != backend.Name()
+
+	if installed && (namespaceChanged || backendChanged || registerRequest.Spec.SkipExternalSecretInstall) {
+		applied.BackendObjects, err = r.deleteBackendObjects(ctx, registerRequest, applied.BackendObjects)
+		if err != nil {
+			return condition, err
+		}
+		err = r.uninstallChart(ctx, registerRequest, appliedNamespace(registerRequest), applied.SecretsBackend)
+		if err != nil {
+			return condition, err
+		}
+		registerStatus.HelmStatus = ""
+		applied.HelmValuesChecksum = ""
+		applied.SecretsBackend = ""
+	}
+
+	ca, err := r.resolveVaultCA(ctx, registerRequest)
+	if err != nil {
+		return condition, err
+	}
+	helmWrapper, err := prepareHelmWrapper(registerRequest, len(ca) != 0)
+	if err != nil {
+		return condition, err
+	}
+	// the mount was just configured by the previous step
+	helmWrapper.MountName = registerStatus.VaultAuthMount
+	objects, err := helmWrapper.Objects()
+	if err != nil {
+		return condition, err
+	}
+
+	if registerRequest.Spec.SkipExternalSecretInstall {
+		// the objects of the backend can still point a provider installed separately at vault
+		if len(objects) != 0 && len(ca) != 0 {
+			if err = r.createCASecret(ctx, registerRequest, ca); err != nil {
+				return condition, err
+			}
+		}
+		if err = r.applyBackendObjects(ctx, registerRequest, applied, objects); err != nil {
+			return condition, err
+		}
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Skipped"
+		condition.Message = "External Secret Install Skipped"
+		return condition, nil
+	}
+
+	condition.Reason = "Installed"
+	helmWrapper.Source, err = r.chartSource(ctx, registerRequest)
+	if err != nil {
+		return condition, err
+	}
+	helmWrapper.ValueOverrides, err = r.valueOverrides(ctx, registerRequest)
+	if err != nil {
+		return condition, err
+	}
+	checksum, err := chartChecksum(helmWrapper, ca)
+	if err != nil {
+		return condition, err
+	}
+	if registerStatus.HelmStatus == "Installed" && checksum == applied.HelmValuesChecksum {
+		// the objects are applied on every pass to undo changes made behind our back
+		if err = r.applyBackendObjects(ctx, registerRequest, applied, objects); err != nil {
+			return condition, err
+		}
+		condition.Message = "chart is up to date"
+		return condition, nil
+	}
+
+	rel, err := r.installChart(ctx, registerRequest, helmWrapper, ca)
+	if err != nil {
+		return condition, err
+	}
+	message := fmt.Sprintf("chart %s-%s revision %d installed in namespace %s", rel.Chart.Metadata.Name,
+		rel.Chart.Metadata.Version, rel.Version, rel.Namespace)
+	r.Recorder.Event(registerRequest, v1.EventTypeNormal, "ChartInstalled", message)
+	registerStatus.HelmStatus = "Installed"
+	applied.HelmValuesChecksum = checksum
+	applied.SecretsBackend = backend.Name()
+	// the CRDs of the chart may take a moment to be served, a failure is retried
+	if err = r.applyBackendObjects(ctx, registerRequest, applied, objects); err != nil {
+		return condition, err
+	}
+	condition.Message = message
+	return condition, nil
+}
+
+// cleanupServiceAccount removes the previous service account after a rename, as long as
+// it was created by the operator for this Register
+func (r *RegisterReconciler) cleanupServiceAccount(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	previous vaultv1alpha1.AppliedSpec) (err error) {
+	if len(previous.ServiceAccount) == 0 {
+		return nil
+	}
+	if previous.ServiceAccount == registerRequest.Spec.ServiceAccount && previous.Namespace == registerRequest.Spec.Namespace {
+		return nil
+	}
+
+	sa := &v1.ServiceAccount{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: previous.Namespace, Name: previous.ServiceAccount}, sa)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if sa.Labels[registerUIDLabel] != string(registerRequest.UID) {
+		return nil
+	}
+	if err = client.IgnoreNotFound(r.Delete(ctx, sa)); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(registerRequest, v1.EventTypeNormal, "ServiceAccountDeleted",
+		"previous service account %s/%s deleted", previous.Namespace, previous.ServiceAccount)
+	return nil
+}
+
+// setCondition records a condition against the generation being reconciled
+func setCondition(registerRequest *vaultv1alpha1.Register, registerStatus *vaultv1alpha1.RegisterStatus,
+	conditionType string, status metav1.ConditionStatus, reason string, message string) {
+	registerStatus.SetCondition(vaultv1alpha1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: registerRequest.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// isReady is true once every step completed for the current generation
+func isReady(registerRequest *vaultv1alpha1.Register) bool {
+	ready := registerRequest.Status.GetCondition(vaultv1alpha1.ConditionReady)
+	return ready != nil && ready.Status == metav1.ConditionTrue &&
+		ready.ObservedGeneration == registerRequest.Generation
+}
+
+// appliedSpec returns the applied spec from the status, initialising it when needed
+func appliedSpec(registerStatus *vaultv1alpha1.RegisterStatus) *vaultv1alpha1.AppliedSpec {
+	if registerStatus.Applied == nil {
+		registerStatus.Applied = &vaultv1alpha1.AppliedSpec{}
+	}
+	return registerStatus.Applied
+}
+
+// appliedNamespace is where the chart was installed, falling back to the spec
+func appliedNamespace(registerRequest *vaultv1alpha1.Register) string {
+	if applied := registerRequest.Status.Applied; applied != nil && len(applied.Namespace) != 0 {
+		return applied.Namespace
+	}
+	return registerRequest.Spec.Namespace
+}
+
+// chartChecksum identifies the chart, its values and CA so changes trigger an upgrade
+func chartChecksum(helmWrapper helm.Wrapper, ca string) (checksum string, err error) {
+	values, err := helmWrapper.Values()
+	if err != nil {
+		return checksum, err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(values+ca+helmWrapper.ChartReference()))), nil
+}
@@ -0,0 +1,314 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/helm"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// drainEvents returns the events recorded since the last call
+func drainEvents(recorder *record.FakeRecorder) (events []string) {
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func conditionStatus(registerStatus *vaultv1alpha1.RegisterStatus, conditionType string) string {
+	if condition := registerStatus.GetCondition(conditionType); condition != nil {
+		return string(condition.Status) + "/" + condition.Reason
+	}
+	return ""
+}
+
+func TestRunSteps(t *testing.T) {
+	// vault refuses every request so configuring the auth mount fails
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "register-uid", Generation: 1},
+		Spec: vaultv1alpha1.RegisterSpec{
+			VaultAddr:      server.URL,
+			ServiceAccount: "vault-auth",
+			Namespace:      "apps",
+			VaultPolicy:    []string{"read"},
+		},
+	}
+	registerRequest.SetDefaults()
+	recorder := record.NewFakeRecorder(100)
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Scheme: scheme.Scheme,
+		Recorder: recorder}
+	registerStatus := &vaultv1alpha1.RegisterStatus{}
+
+	// the first failing step stops the run, later steps are not attempted
+	if err := r.runSteps(ctx, registerRequest, registerStatus); err == nil {
+		t.Fatalf("expected missing vault credentials to fail")
+	}
+	want := map[string]string{
+		vaultv1alpha1.ConditionTokenAvailable:      "False/TokenUnavailable",
+		vaultv1alpha1.ConditionServiceAccountReady: "",
+		vaultv1alpha1.ConditionVaultAuthConfigured: "",
+		vaultv1alpha1.ConditionReady:               "False/TokenUnavailable",
+	}
+	for conditionType, status := range want {
+		if got := conditionStatus(registerStatus, conditionType); got != status {
+			t.Errorf("%s = %q, want %q", conditionType, got, status)
+		}
+	}
+	drainEvents(recorder)
+
+	token := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultSecret, Namespace: operatorNamespace()},
+		Data:       map[string][]byte{tokenKey: []byte("root")},
+	}
+	if err := r.Create(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := r.runSteps(ctx, registerRequest, registerStatus); err == nil {
+			t.Fatalf("expected the refused vault to fail")
+		}
+		events := drainEvents(recorder)
+		// only transitions are recorded, the failure itself every time
+		wantEvents := []string{"Warning VaultAuthFailed"}
+		if attempt == 0 {
+			wantEvents = []string{
+				"Normal " + vaultv1alpha1.ConditionTokenAvailable,
+				"Normal " + vaultv1alpha1.ConditionServiceAccountReady,
+				"Warning VaultAuthFailed",
+			}
+		}
+		if len(events) != len(wantEvents) {
+			t.Fatalf("attempt %d: events %v, want %v", attempt, events, wantEvents)
+		}
+		for i := range wantEvents {
+			if !strings.HasPrefix(events[i], wantEvents[i]) {
+				t.Fatalf("attempt %d: events %v, want %v", attempt, events, wantEvents)
+			}
+		}
+	}
+	want = map[string]string{
+		vaultv1alpha1.ConditionTokenAvailable:           "True/CredentialsFound",
+		vaultv1alpha1.ConditionServiceAccountReady:      "True/Created",
+		vaultv1alpha1.ConditionVaultAuthConfigured:      "False/VaultAuthFailed",
+		vaultv1alpha1.ConditionExternalSecretsInstalled: "",
+		vaultv1alpha1.ConditionReady:                    "False/VaultAuthFailed",
+	}
+	for conditionType, status := range want {
+		if got := conditionStatus(registerStatus, conditionType); got != status {
+			t.Errorf("%s = %q, want %q", conditionType, got, status)
+		}
+	}
+	if registerStatus.ObservedGeneration != 0 {
+		t.Fatalf("generation %d observed before every step succeeded", registerStatus.ObservedGeneration)
+	}
+}
+
+func TestMigrateLegacyStatus(t *testing.T) {
+	ctx := context.Background()
+	// the shape older versions left behind once every step had succeeded
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "register-uid", Generation: 3},
+		Spec: vaultv1alpha1.RegisterSpec{
+			VaultAddr:      "https://vault.example.com:8200",
+			ServiceAccount: "vault-auth",
+			Namespace:      "apps",
+			VaultPolicy:    []string{"read"},
+		},
+		Status: vaultv1alpha1.RegisterStatus{
+			LegacyStatus:   legacyProcessed,
+			VaultAuthMount: "k8s-old",
+			HelmStatus:     "Installed",
+		},
+	}
+	registerRequest.SetDefaults()
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Scheme: scheme.Scheme,
+		Recorder: record.NewFakeRecorder(100)}
+
+	// a Register part way through an older version is set up by the steps again
+	partial := registerRequest.DeepCopy()
+	partial.Status.LegacyStatus = "ServiceAccountCreated"
+	if err := r.migrateLegacyStatus(ctx, partial, &partial.Status); err != nil || len(partial.Status.Conditions) != 0 {
+		t.Fatalf("unfinished Register migrated: %v %v", partial.Status.Conditions, err)
+	}
+
+	registerStatus := registerRequest.Status.DeepCopy()
+	if err := r.migrateLegacyStatus(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	registerRequest.Status = *registerStatus
+	if !isReady(registerRequest) {
+		t.Fatalf("migrated Register is not ready: %v", registerStatus.Conditions)
+	}
+	for _, step := range r.steps() {
+		if got := conditionStatus(registerStatus, step.conditionType); got != "True/Migrated" {
+			t.Errorf("%s = %q, want True/Migrated", step.conditionType, got)
+		}
+	}
+	applied := registerStatus.Applied
+	if registerStatus.LegacyStatus != "" || registerStatus.VaultAuthMount != "k8s-old" ||
+		applied.VaultAddr != registerRequest.Spec.VaultAddr || applied.Namespace != "apps" ||
+		applied.ServiceAccount != "vault-auth" || len(applied.SecretsBackend) == 0 {
+		t.Fatalf("unexpected migrated status %+v %+v", registerStatus, applied)
+	}
+	// the reviewer did not exist before and is the only thing set up
+	sa := &v1.ServiceAccount{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: operatorNamespace(),
+		Name: applied.ReviewerServiceAccount}, sa); err != nil {
+		t.Fatalf("reviewer not created: %v", err)
+	}
+}
+
+// authVault fakes the auth mounts and roles of a vault for the vault auth step
+type authVault struct {
+	sync.Mutex
+	mounts map[string]string
+	roles  map[string][]interface{}
+}
+
+func (av *authVault) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	av.Lock()
+	defer av.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	body := map[string]interface{}{}
+	_ = json.NewDecoder(req.Body).Decode(&body)
+	path := strings.TrimPrefix(req.URL.Path, "/v1/")
+	switch {
+	case path == "sys/auth" && req.Method == http.MethodGet:
+		mounts := map[string]interface{}{}
+		for mount, description := range av.mounts {
+			mounts[mount+"/"] = map[string]interface{}{"type": "kubernetes", "description": description}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": mounts})
+		return
+	case strings.HasPrefix(path, "sys/auth/") && req.Method == http.MethodPost:
+		av.mounts[strings.TrimPrefix(path, "sys/auth/")] = body["description"].(string)
+	case strings.HasPrefix(path, "auth/k8s-demo/role/"):
+		name := strings.TrimPrefix(path, "auth/k8s-demo/role/")
+		if req.Method == http.MethodDelete {
+			delete(av.roles, name)
+		} else {
+			av.roles[name], _ = body["bound_service_account_names"].([]interface{})
+		}
+	case path == "auth/k8s-demo/config":
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprintf(w, `{"errors":["unexpected request %s %s"]}`, req.Method, req.URL.Path)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestRunStepsAfterRename(t *testing.T) {
+	av := &authVault{mounts: map[string]string{}, roles: map[string][]interface{}{}}
+	server := httptest.NewServer(av)
+	defer server.Close()
+
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default", UID: "register-uid", Generation: 1},
+		Spec: vaultv1alpha1.RegisterSpec{
+			VaultAddr:                 server.URL,
+			ServiceAccount:            "old-sa",
+			Namespace:                 "apps",
+			VaultPolicy:               []string{"read"},
+			K8SEndpoint:               "https://10.0.0.1:6443",
+			MountPath:                 "k8s-demo",
+			TokenReviewer:             &vaultv1alpha1.TokenReviewerSpec{OmitJWT: true},
+			SecretsBackend:            helm.BackendVaultCSIProvider,
+			SkipExternalSecretInstall: true,
+		},
+	}
+	registerRequest.SetDefaults()
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultSecret, Namespace: operatorNamespace()},
+			Data:       map[string][]byte{tokenKey: []byte("root")},
+		},
+		&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: rootCAConfigMap, Namespace: operatorNamespace()},
+			Data:       map[string]string{caKey: "ca"},
+		},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "cluster-uid"}},
+	), Scheme: scheme.Scheme, Recorder: record.NewFakeRecorder(100), Log: ctrl.Log}
+	registerStatus := &vaultv1alpha1.RegisterStatus{}
+
+	if err := r.runSteps(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]interface{}{"demo": {"old-sa"}}; !reflect.DeepEqual(av.roles, want) {
+		t.Fatalf("roles in vault %v, want %v", av.roles, want)
+	}
+	registerRequest.Status = *registerStatus
+	if !isReady(registerRequest) {
+		t.Fatalf("Register not ready: %v", registerStatus.Conditions)
+	}
+
+	// a new generation is applied again even though the Register was ready
+	registerRequest.Generation = 2
+	registerRequest.Spec.RoleName = "renamed"
+	registerRequest.Spec.ServiceAccount = "new-sa"
+	if isReady(registerRequest) {
+		t.Fatalf("Register ready before the new generation was applied")
+	}
+	if err := r.runSteps(ctx, registerRequest, registerStatus); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]interface{}{"renamed": {"new-sa"}}; !reflect.DeepEqual(av.roles, want) {
+		t.Fatalf("roles in vault %v, want %v", av.roles, want)
+	}
+	if registerStatus.ObservedGeneration != 2 || registerStatus.Applied.ServiceAccount != "new-sa" {
+		t.Fatalf("new generation not recorded: %+v %+v", registerStatus, registerStatus.Applied)
+	}
+	sa := &v1.ServiceAccount{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "old-sa"}, sa); !errors.IsNotFound(err) {
+		t.Fatalf("previous service account not deleted: %v", err)
+	}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: "apps", Name: "new-sa"}, sa); err != nil ||
+		!ownedBy(registerRequest, sa.Labels) {
+		t.Fatalf("renamed service account not created for the Register: %v %v", sa.Labels, err)
+	}
+}
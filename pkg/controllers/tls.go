@@ -0,0 +1,78 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+)
+
+const caKey = "ca.crt"
+
+// configureVaultTLS populates the tls settings used by the operator to talk to vault
+func (r *RegisterReconciler) configureVaultTLS(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register, v *vault.VaultRegister) (err error) {
+	v.Insecure = registerRequest.Spec.SSLDisable
+	ca, err := r.resolveVaultCA(ctx, registerRequest)
+	if err != nil {
+		return err
+	}
+	v.CACert = []byte(ca)
+
+	tlsSpec := registerRequest.Spec.VaultTLS
+	if tlsSpec == nil {
+		return nil
+	}
+	v.TLSServerName = tlsSpec.ServerName
+	if len(tlsSpec.ClientCertSecret) != 0 {
+		secret, err := r.operatorSecret(ctx, tlsSpec.ClientCertSecret, "")
+		if err != nil {
+			return err
+		}
+		clientCert, err := secretValue(secret, v1.TLSCertKey)
+		if err != nil {
+			return err
+		}
+		clientKey, err := secretValue(secret, v1.TLSPrivateKeyKey)
+		if err != nil {
+			return err
+		}
+		v.ClientCert = []byte(clientCert)
+		v.ClientKey = []byte(clientKey)
+	}
+	return nil
+}
+
+// resolveVaultCA returns the PEM encoded vault CA from the spec or the referenced secret
+func (r *RegisterReconciler) resolveVaultCA(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (ca string, err error) {
+	if len(registerRequest.Spec.VaultCACert) != 0 {
+		return registerRequest.Spec.VaultCACert, nil
+	}
+	tlsSpec := registerRequest.Spec.VaultTLS
+	if tlsSpec != nil && len(tlsSpec.CASecret) != 0 {
+		secret, err := r.operatorSecret(ctx, tlsSpec.CASecret, "")
+		if err != nil {
+			return ca, err
+		}
+		return secretValue(secret, caKey)
+	}
+	return ca, nil
+}
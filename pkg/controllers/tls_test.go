@@ -0,0 +1,66 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"github.com/ibrokethecloud/vault-glue-operator/pkg/vault"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestConfigureVaultTLSSecrets(t *testing.T) {
+	ctx := context.Background()
+	labelled := map[string]string{registerSecretLabel: "true"}
+	secret := func(name string, labels map[string]string, data map[string][]byte) *v1.Secret {
+		return &v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: operatorNamespace(),
+			Labels: labels}, Data: data}
+	}
+	clientCert := map[string][]byte{caKey: []byte("ca"), v1.TLSCertKey: []byte("cert"),
+		v1.TLSPrivateKeyKey: []byte("key")}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme,
+		secret("vault-ca", labelled, map[string][]byte{caKey: []byte("ca")}),
+		secret("vault-client", labelled, clientCert),
+		secret("webhook-tls", nil, clientCert),
+	), Scheme: scheme.Scheme}
+
+	registerRequest := &vaultv1alpha1.Register{Spec: vaultv1alpha1.RegisterSpec{VaultTLS: &vaultv1alpha1.VaultTLSSpec{
+		CASecret: "vault-ca", ClientCertSecret: "vault-client"}}}
+	v := &vault.VaultRegister{}
+	if err := r.configureVaultTLS(ctx, registerRequest, v); err != nil {
+		t.Fatal(err)
+	}
+	if string(v.CACert) != "ca" || string(v.ClientCert) != "cert" || string(v.ClientKey) != "key" {
+		t.Fatalf("unexpected tls settings %+v", v)
+	}
+
+	// other secrets of the operator namespace are neither used nor copied to the Register
+	for _, tlsSpec := range []*vaultv1alpha1.VaultTLSSpec{
+		{CASecret: "webhook-tls"},
+		{ClientCertSecret: "webhook-tls"},
+	} {
+		registerRequest.Spec.VaultTLS = tlsSpec
+		if err := r.configureVaultTLS(ctx, registerRequest, &vault.VaultRegister{}); err == nil || isPermanent(err) {
+			t.Fatalf("expected unlabelled secret in %+v to be retried, got %v", tlsSpec, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// reviewerTokenSecretName is the token secret the operator owns for a service account
+func reviewerTokenSecretName(serviceAccount string) string {
+	return serviceAccount + "-vault-token"
+}
+
+// reviewerToken returns the JWT and CA of the service account vault uses to review tokens.
+// Kubernetes 1.24 and later no longer generate token secrets for service accounts, so the
+// operator always uses a token secret of its own, populated by the token controller. Its
+// name is derived from the service account which keeps the selection deterministic
+func (r *RegisterReconciler) reviewerToken(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	sa *v1.ServiceAccount) (token string, caCert string, err error) {
+	secret := &v1.Secret{}
+	key := types.NamespacedName{Namespace: sa.Namespace, Name: reviewerTokenSecretName(sa.Name)}
+	err = r.Get(ctx, key, secret)
+	if errors.IsNotFound(err) {
+		return token, caCert, r.createReviewerTokenSecret(ctx, registerRequest, sa)
+	}
+	if err != nil {
+		return token, caCert, err
+	}
+
+	if secret.Type != v1.SecretTypeServiceAccountToken || secret.Annotations[v1.ServiceAccountNameKey] != sa.Name {
+		return token, caCert, permanentf("secret %s/%s is not a token secret of service account %s",
+			key.Namespace, key.Name, sa.Name)
+	}
+	if uid, ok := secret.Annotations[v1.ServiceAccountUIDKey]; ok && uid != string(sa.UID) {
+		// left behind by a deleted service account of the same name
+		if err = client.IgnoreNotFound(r.Delete(ctx, secret)); err != nil {
+			return token, caCert, err
+		}
+		return token, caCert, r.createReviewerTokenSecret(ctx, registerRequest, sa)
+	}
+	if len(secret.Data[v1.ServiceAccountTokenKey]) == 0 {
+		return token, caCert, fmt.Errorf("waiting for the token controller to populate secret %s/%s",
+			key.Namespace, key.Name)
+	}
+	return string(secret.Data[v1.ServiceAccountTokenKey]), string(secret.Data[v1.ServiceAccountRootCAKey]), nil
+}
+
+// createReviewerTokenSecret creates the token secret. It is owned by the service account
+// so it is garbage collected with it. The token is only available once the token
+// controller has populated the secret, so an error is always returned to retry later
+func (r *RegisterReconciler) createReviewerTokenSecret(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	sa *v1.ServiceAccount) (err error) {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        reviewerTokenSecretName(sa.Name),
+			Namespace:   sa.Namespace,
+			Labels:      map[string]string{registerUIDLabel: string(registerRequest.UID)},
+			Annotations: map[string]string{v1.ServiceAccountNameKey: sa.Name},
+		},
+		Type: v1.SecretTypeServiceAccountToken,
+	}
+	if err = controllerutil.SetControllerReference(sa, secret, r.Scheme); err != nil {
+		return err
+	}
+	if err = r.Create(ctx, secret); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return fmt.Errorf("waiting for the token controller to populate secret %s/%s", secret.Namespace, secret.Name)
+}
@@ -0,0 +1,77 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReviewerToken(t *testing.T) {
+	ctx := context.Background()
+	registerRequest := &vaultv1alpha1.Register{ObjectMeta: metav1.ObjectMeta{Name: "demo", UID: "register-uid"}}
+	sa := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "vault-auth", Namespace: "kube-external-secrets",
+		UID: "sa-uid"}}
+	key := types.NamespacedName{Namespace: sa.Namespace, Name: reviewerTokenSecretName(sa.Name)}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, sa), Scheme: scheme.Scheme}
+
+	if _, _, err := r.reviewerToken(ctx, registerRequest, sa); err == nil {
+		t.Fatalf("expected to wait for the token controller")
+	}
+	secret := &v1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		t.Fatalf("token secret not created: %v", err)
+	}
+	if secret.Type != v1.SecretTypeServiceAccountToken || secret.Annotations[v1.ServiceAccountNameKey] != sa.Name {
+		t.Fatalf("unexpected token secret %+v", secret)
+	}
+	if owner := metav1.GetControllerOf(secret); owner == nil || owner.UID != sa.UID {
+		t.Fatalf("token secret is not owned by the service account")
+	}
+
+	// what the token controller does
+	secret.Annotations[v1.ServiceAccountUIDKey] = string(sa.UID)
+	secret.Data = map[string][]byte{v1.ServiceAccountTokenKey: []byte("jwt"), v1.ServiceAccountRootCAKey: []byte("ca")}
+	if err := r.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	token, caCert, err := r.reviewerToken(ctx, registerRequest, sa)
+	if err != nil || token != "jwt" || caCert != "ca" {
+		t.Fatalf("reviewerToken() = %q, %q, %v", token, caCert, err)
+	}
+
+	// the service account was recreated with the same name
+	recreated := sa.DeepCopy()
+	recreated.UID = "new-sa-uid"
+	if _, _, err := r.reviewerToken(ctx, registerRequest, recreated); err == nil {
+		t.Fatalf("expected to wait for a new token")
+	}
+	secret = &v1.Secret{}
+	if err := r.Get(ctx, key, secret); err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data) != 0 {
+		t.Fatalf("token of the previous service account was kept")
+	}
+}
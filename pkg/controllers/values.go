@@ -0,0 +1,146 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	"helm.sh/helm/v3/pkg/chartutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const defaultValuesKey = "values.yaml"
+
+// valueOverrides reads the helm values of the Register in order of precedence, lowest first.
+// Errors in referenced objects are retried as fixing them does not change the spec
+func (r *RegisterReconciler) valueOverrides(ctx context.Context,
+	registerRequest *vaultv1alpha1.Register) (overrides []map[string]interface{}, err error) {
+	for _, ref := range registerRequest.Spec.HelmValuesFrom {
+		data, found, err := r.valuesFrom(ctx, registerRequest.Namespace, ref)
+		if err != nil {
+			return overrides, err
+		}
+		if !found {
+			if ref.Optional {
+				continue
+			}
+			return overrides, fmt.Errorf("helm values %s not found in %s %s", valuesKey(ref), ref.Kind, ref.Name)
+		}
+		values, err := chartutil.ReadValues(data)
+		if err != nil {
+			return overrides, fmt.Errorf("unable to parse helm values %s in %s %s: %v", valuesKey(ref),
+				ref.Kind, ref.Name, err)
+		}
+		overrides = append(overrides, values)
+	}
+
+	if helmValues := registerRequest.Spec.HelmValues; helmValues != nil && len(helmValues.Raw) != 0 {
+		values, err := chartutil.ReadValues(helmValues.Raw)
+		if err != nil {
+			return overrides, permanentf("unable to parse helmValues: %v", err)
+		}
+		overrides = append(overrides, values)
+	}
+	return overrides, nil
+}
+
+func (r *RegisterReconciler) valuesFrom(ctx context.Context, namespace string,
+	ref vaultv1alpha1.ValuesReference) (data []byte, found bool, err error) {
+	key := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+	switch ref.Kind {
+	case "ConfigMap":
+		configMap := &v1.ConfigMap{}
+		if err = r.Get(ctx, key, configMap); err != nil {
+			break
+		}
+		if value, ok := configMap.Data[valuesKey(ref)]; ok {
+			return []byte(value), true, nil
+		}
+		data, found = configMap.BinaryData[valuesKey(ref)]
+		return data, found, nil
+	case "Secret":
+		secret := &v1.Secret{}
+		if err = r.Get(ctx, key, secret); err != nil {
+			break
+		}
+		data, found = secret.Data[valuesKey(ref)]
+		return data, found, nil
+	default:
+		return data, false, permanentf("unsupported helm values kind %s", ref.Kind)
+	}
+	if errors.IsNotFound(err) {
+		return data, false, nil
+	}
+	return data, false, err
+}
+
+func valuesKey(ref vaultv1alpha1.ValuesReference) string {
+	if len(ref.Key) == 0 {
+		return defaultValuesKey
+	}
+	return ref.Key
+}
+
+// refreshChartValues upgrades the chart of a processed Register when the ConfigMaps and
+// Secrets holding its helm values change
+func (r *RegisterReconciler) refreshChartValues(ctx context.Context, registerRequest *vaultv1alpha1.Register,
+	registerStatus *vaultv1alpha1.RegisterStatus) (err error) {
+	if len(registerRequest.Spec.HelmValuesFrom) == 0 || registerRequest.Spec.SkipExternalSecretInstall {
+		return nil
+	}
+	condition, err := r.reconcileChart(ctx, registerRequest, registerStatus)
+	if err != nil {
+		r.Recorder.Event(registerRequest, v1.EventTypeWarning, "ChartInstallFailed", err.Error())
+		setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionExternalSecretsInstalled,
+			metav1.ConditionFalse, "ChartInstallFailed", err.Error())
+		return err
+	}
+	setCondition(registerRequest, registerStatus, vaultv1alpha1.ConditionExternalSecretsInstalled,
+		metav1.ConditionTrue, condition.Reason, condition.Message)
+	return nil
+}
+
+// registersForValues maps a ConfigMap or Secret to the Registers reading helm values from it
+func (r *RegisterReconciler) registersForValues(object handler.MapObject) []reconcile.Request {
+	var kind string
+	switch object.Object.(type) {
+	case *v1.ConfigMap:
+		kind = "ConfigMap"
+	case *v1.Secret:
+		kind = "Secret"
+	default:
+		return nil
+	}
+	return r.registerRequests(func(registerRequest *vaultv1alpha1.Register) bool {
+		if registerRequest.Namespace != object.Meta.GetNamespace() {
+			return false
+		}
+		for _, ref := range registerRequest.Spec.HelmValuesFrom {
+			if ref.Kind == kind && ref.Name == object.Meta.GetName() {
+				return true
+			}
+		}
+		return false
+	})
+}
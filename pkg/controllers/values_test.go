@@ -0,0 +1,85 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	vaultv1alpha1 "github.com/ibrokethecloud/vault-glue-operator/pkg/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestValueOverrides(t *testing.T) {
+	ctx := context.Background()
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "chart-values", Namespace: "default"},
+		Data:       map[string]string{defaultValuesKey: "replicaCount: 2\nenv:\n  LOG_LEVEL: debug\n"},
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "chart-secrets", Namespace: "default"},
+		Data:       map[string][]byte{"custom.yaml": []byte("replicaCount: 3\n")},
+	}
+	registerRequest := &vaultv1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: vaultv1alpha1.RegisterSpec{
+			HelmValuesFrom: []vaultv1alpha1.ValuesReference{
+				{Kind: "ConfigMap", Name: "chart-values"},
+				{Kind: "Secret", Name: "chart-secrets", Key: "custom.yaml"},
+				{Kind: "ConfigMap", Name: "missing", Optional: true},
+			},
+			HelmValues: &runtime.RawExtension{Raw: []byte(`{"replicaCount":4}`)},
+		},
+	}
+	registerScheme := runtime.NewScheme()
+	if err := scheme.AddToScheme(registerScheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := vaultv1alpha1.AddToScheme(registerScheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &RegisterReconciler{Client: fake.NewFakeClientWithScheme(registerScheme, configMap, secret, registerRequest)}
+
+	overrides, err := r.valueOverrides(ctx, registerRequest)
+	if err != nil || len(overrides) != 3 {
+		t.Fatalf("unexpected overrides %v %v", overrides, err)
+	}
+	if overrides[0]["replicaCount"] != float64(2) || overrides[1]["replicaCount"] != float64(3) ||
+		overrides[2]["replicaCount"] != float64(4) {
+		t.Fatalf("overrides not in order of precedence: %v", overrides)
+	}
+
+	registerRequest.Spec.HelmValuesFrom[2].Optional = false
+	if _, err = r.valueOverrides(ctx, registerRequest); err == nil || isPermanent(err) {
+		t.Fatalf("expected a missing ConfigMap to be retried, got %v", err)
+	}
+
+	requests := r.registersForValues(handler.MapObject{Meta: secret, Object: secret})
+	if len(requests) != 1 || requests[0].Name != "demo" {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+	// a ConfigMap named like the Secret is not referenced
+	other := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "chart-secrets", Namespace: "default"}}
+	if requests = r.registersForValues(handler.MapObject{Meta: other, Object: other}); len(requests) != 0 {
+		t.Fatalf("unexpected requests %v", requests)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// helmValuesLabel marks the ConfigMaps and Secrets of helmValuesFrom which are watched
+const helmValuesLabel = "vault.cattle.io/helm-values"
+
+// NewClient is the manager client. Secrets and ConfigMaps are read from the api server so
+// they are never cached for the whole cluster, only the ones watched are
+func NewClient(cache cache.Cache, config *rest.Config, options client.Options) (client.Client, error) {
+	c, err := client.New(config, options)
+	if err != nil {
+		return nil, err
+	}
+	return &client.DelegatingClient{
+		Reader: &uncachedReader{
+			Reader: &client.DelegatingReader{CacheReader: cache, ClientReader: c},
+			direct: c,
+		},
+		Writer:       c,
+		StatusClient: c,
+	}, nil
+}
+
+// uncachedReader sends reads of Secrets and ConfigMaps to the api server
+type uncachedReader struct {
+	client.Reader
+	direct client.Reader
+}
+
+func (u *uncachedReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	switch obj.(type) {
+	case *v1.Secret, *v1.ConfigMap:
+		return u.direct.Get(ctx, key, obj)
+	}
+	return u.Reader.Get(ctx, key, obj)
+}
+
+func (u *uncachedReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	switch list.(type) {
+	case *v1.SecretList, *v1.ConfigMapList:
+		return u.direct.List(ctx, list, opts...)
+	}
+	return u.Reader.List(ctx, list, opts...)
+}
+
+// watchSources returns informers for the Secrets and ConfigMaps the Registers depend on: the
+// reviewer token secrets and cluster CA in the operator namespace and the helm values labelled
+// with helmValuesLabel. They are started with the manager
+func (r *RegisterReconciler) watchSources(mgr manager.Manager) (reviewerSecrets source.Source,
+	rootCA source.Source, valuesConfigMaps source.Source, valuesSecrets source.Source, err error) {
+	operator := informers.NewSharedInformerFactoryWithOptions(r.KubeClient, 0,
+		informers.WithNamespace(operatorNamespace()),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = registerUIDLabel
+		}))
+	caConfigMap := informers.NewSharedInformerFactoryWithOptions(r.KubeClient, 0,
+		informers.WithNamespace(operatorNamespace()),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", rootCAConfigMap).String()
+		}))
+	values := informers.NewSharedInformerFactoryWithOptions(r.KubeClient, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = helmValuesLabel + "=true"
+		}))
+
+	reviewerSecrets = &source.Informer{Informer: operator.Core().V1().Secrets().Informer()}
+	rootCA = &source.Informer{Informer: caConfigMap.Core().V1().ConfigMaps().Informer()}
+	valuesConfigMaps = &source.Informer{Informer: values.Core().V1().ConfigMaps().Informer()}
+	valuesSecrets = &source.Informer{Informer: values.Core().V1().Secrets().Informer()}
+	err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		for _, factory := range []informers.SharedInformerFactory{operator, caConfigMap, values} {
+			factory.Start(stop)
+		}
+		<-stop
+		return nil
+	}))
+	return reviewerSecrets, rootCA, valuesConfigMaps, valuesSecrets, err
+}
@@ -0,0 +1,53 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUncachedReader(t *testing.T) {
+	ctx := context.Background()
+	meta := metav1.ObjectMeta{Name: "demo", Namespace: "apps"}
+	// the cache only holds a service account, the api server everything
+	cached := fake.NewFakeClientWithScheme(scheme.Scheme, &v1.ServiceAccount{ObjectMeta: meta})
+	direct := fake.NewFakeClientWithScheme(scheme.Scheme, &v1.Secret{ObjectMeta: meta},
+		&v1.ConfigMap{ObjectMeta: meta})
+	reader := &uncachedReader{Reader: cached, direct: direct}
+
+	key := types.NamespacedName{Namespace: "apps", Name: "demo"}
+	if err := reader.Get(ctx, key, &v1.Secret{}); err != nil {
+		t.Fatalf("secret not read from the api server: %v", err)
+	}
+	if err := reader.Get(ctx, key, &v1.ConfigMap{}); err != nil {
+		t.Fatalf("configmap not read from the api server: %v", err)
+	}
+	if err := reader.Get(ctx, key, &v1.ServiceAccount{}); err != nil {
+		t.Fatalf("service account not read from the cache: %v", err)
+	}
+	secrets := &v1.SecretList{}
+	if err := reader.List(ctx, secrets); err != nil || len(secrets.Items) != 1 {
+		t.Fatalf("secrets not listed from the api server: %v %v", secrets.Items, err)
+	}
+}
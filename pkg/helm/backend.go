@@ -0,0 +1,99 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	BackendKubernetesExternalSecrets = "KubernetesExternalSecrets"
+	BackendExternalSecretsOperator   = "ExternalSecretsOperator"
+	BackendVaultAgentInjector        = "VaultAgentInjector"
+	BackendVaultCSIProvider          = "VaultCSIProvider"
+)
+
+// Backend is a secrets backend installed with a chart into the namespace of a Register
+type Backend interface {
+	// Name selects the backend in the Register spec
+	Name() string
+	ReleaseName() string
+	// Shared backends run cluster wide. Their chart is installed once into the operator
+	// namespace for every Register using them, so its values must not depend on the Register
+	Shared() bool
+	// ChartName and ChartVersion identify the bundled chart
+	ChartName() string
+	ChartVersion() string
+	// Values renders the chart values for the Register
+	Values(w *Wrapper) (values bytes.Buffer, err error)
+	// ProtectedValues are the dotted paths of values set by the operator, which overrides
+	// only replace when asked to explicitly
+	ProtectedValues() []string
+	// Objects are applied once the chart is installed, e.g. stores or classes pointing at vault
+	Objects(w *Wrapper) (objects []*unstructured.Unstructured, err error)
+}
+
+var backends = map[string]Backend{}
+
+// RegisterBackend makes a backend available to Registers
+func RegisterBackend(backend Backend) {
+	backends[backend.Name()] = backend
+}
+
+// GetBackend returns the named backend, kubernetes-external-secrets when name is empty
+func GetBackend(name string) (backend Backend, err error) {
+	if len(name) == 0 {
+		name = BackendKubernetesExternalSecrets
+	}
+	backend, ok := backends[name]
+	if !ok {
+		names := []string{}
+		for known := range backends {
+			names = append(names, known)
+		}
+		sort.Strings(names)
+		return backend, fmt.Errorf("unknown secrets backend %s, expected one of %v", name, names)
+	}
+	return backend, nil
+}
+
+func init() {
+	RegisterBackend(kesBackend{})
+	RegisterBackend(esoBackend{})
+	RegisterBackend(injectorBackend{})
+	RegisterBackend(csiBackend{})
+}
+
+func renderValues(name string, valuesTemplate string, w *Wrapper) (output bytes.Buffer, err error) {
+	t, err := template.New(name).Parse(valuesTemplate)
+	if err != nil {
+		return output, err
+	}
+	err = t.Execute(&output, w)
+	return output, err
+}
+
+// kesBackend installs the deprecated kubernetes-external-secrets chart, configured through env vars
+type kesBackend struct{}
+
+func (kesBackend) Name() string         { return BackendKubernetesExternalSecrets }
+func (kesBackend) ReleaseName() string  { return ReleaseName }
+func (kesBackend) ChartName() string    { return DefaultChartName }
+func (kesBackend) ChartVersion() string { return ChartVersion }
+func (kesBackend) Shared() bool         { return false }
+
+func (kesBackend) Values(w *Wrapper) (bytes.Buffer, error) {
+	return renderValues("ValuesYaml", ValuesYaml, w)
+}
+
+func (kesBackend) ProtectedValues() []string {
+	return []string{"env.VAULT_ADDR", "env.VAULT_NAMESPACE", "env.VAULT_SKIP_VERIFY",
+		"env.DEFAULT_VAULT_MOUNT_POINT", "env.DEFAULT_VAULT_ROLE", "serviceAccount.create", "serviceAccount.name"}
+}
+
+func (kesBackend) Objects(w *Wrapper) ([]*unstructured.Unstructured, error) {
+	return nil, nil
+}
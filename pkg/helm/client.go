@@ -0,0 +1,195 @@
+package helm
+
+import (
+	"fmt"
+	"time"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/rest"
+)
+
+// DefaultPendingTimeout matches the default timeout of helm actions
+const DefaultPendingTimeout = 5 * time.Minute
+
+// Client runs helm actions in-process, storing releases as secrets in the release namespace
+// the same way the helm CLI does
+type Client struct {
+	// configure returns the action configuration for a release namespace
+	configure func(namespace string) (*action.Configuration, error)
+	Log       action.DebugLog
+	// PendingTimeout is how long a pending release is waited for. Past it the operation is
+	// considered failed, e.g. when the operator was restarted in the middle of an upgrade
+	PendingTimeout time.Duration
+}
+
+// NewClient returns a client talking to the cluster of config. The mapper is shared
+// with the controller-runtime manager
+func NewClient(config *rest.Config, mapper meta.RESTMapper) *Client {
+	c := &Client{Log: func(format string, v ...interface{}) {}, PendingTimeout: DefaultPendingTimeout}
+	c.configure = func(namespace string) (cfg *action.Configuration, err error) {
+		cfg = &action.Configuration{}
+		err = cfg.Init(newRESTClientGetter(config, mapper, namespace), namespace, "secret", c.Log)
+		return cfg, err
+	}
+	return c
+}
+
+// ReleaseError is returned when a helm action fails. Status is the state the release was
+// left in, empty when the release does not exist
+type ReleaseError struct {
+	Action    string
+	Release   string
+	Namespace string
+	Status    release.Status
+	Err       error
+}
+
+func (e *ReleaseError) Error() string {
+	if len(e.Status) == 0 {
+		return fmt.Sprintf("helm %s of %s in namespace %s failed: %v", e.Action, e.Release, e.Namespace, e.Err)
+	}
+	return fmt.Sprintf("helm %s of %s in namespace %s failed, release is %s: %v", e.Action, e.Release,
+		e.Namespace, e.Status, e.Err)
+}
+
+// InstallOrUpgrade installs the release, or upgrades it when a revision was deployed before.
+// A release which never deployed successfully is removed and installed again. A release left
+// pending for longer than PendingTimeout is rolled back first, or removed if it never deployed
+func (c *Client) InstallOrUpgrade(name string, namespace string, chrt *chart.Chart,
+	values map[string]interface{}) (rel *release.Release, err error) {
+	cfg, err := c.configure(namespace)
+	if err != nil {
+		return rel, err
+	}
+	history, err := cfg.Releases.History(name)
+	if err != nil && err != driver.ErrReleaseNotFound {
+		return rel, err
+	}
+
+	last := latest(history)
+	switch {
+	case last == nil:
+		rel, err = c.install(cfg, name, namespace, chrt, values)
+	case isPending(last.Info.Status) && time.Since(last.Info.LastDeployed.Time) < c.PendingTimeout:
+		return rel, &ReleaseError{Action: "upgrade", Release: name, Namespace: namespace, Status: last.Info.Status,
+			Err: fmt.Errorf("another operation is in progress")}
+	case deployedBefore(history):
+		if isPending(last.Info.Status) {
+			if err = c.rollback(cfg, name, namespace, last, history); err != nil {
+				return rel, err
+			}
+		}
+		upgrade := action.NewUpgrade(cfg)
+		upgrade.Namespace = namespace
+		rel, err = upgrade.Run(name, chrt, values)
+		if err != nil {
+			return rel, c.releaseError(cfg, "upgrade", name, namespace, err)
+		}
+	default:
+		if _, err = action.NewUninstall(cfg).Run(name); err != nil {
+			return rel, c.releaseError(cfg, "uninstall", name, namespace, err)
+		}
+		rel, err = c.install(cfg, name, namespace, chrt, values)
+	}
+	return rel, err
+}
+
+// rollback marks the stale pending revision failed and goes back to the last deployed one
+func (c *Client) rollback(cfg *action.Configuration, name string, namespace string, pending *release.Release,
+	history []*release.Release) (err error) {
+	pending.SetStatus(release.StatusFailed, fmt.Sprintf("%s timed out", pending.Info.Status))
+	if err = cfg.Releases.Update(pending); err != nil {
+		return err
+	}
+	rollback := action.NewRollback(cfg)
+	rollback.Version = lastDeployed(history).Version
+	if err = rollback.Run(name); err != nil {
+		return c.releaseError(cfg, "rollback", name, namespace, err)
+	}
+	return nil
+}
+
+func (c *Client) install(cfg *action.Configuration, name string, namespace string, chrt *chart.Chart,
+	values map[string]interface{}) (rel *release.Release, err error) {
+	install := action.NewInstall(cfg)
+	install.ReleaseName = name
+	install.Namespace = namespace
+	rel, err = install.Run(chrt, values)
+	if err != nil {
+		return rel, c.releaseError(cfg, "install", name, namespace, err)
+	}
+	return rel, nil
+}
+
+// Uninstall removes the release. A release which does not exist is not an error
+func (c *Client) Uninstall(name string, namespace string) (err error) {
+	cfg, err := c.configure(namespace)
+	if err != nil {
+		return err
+	}
+	history, err := cfg.Releases.History(name)
+	if err == driver.ErrReleaseNotFound || (err == nil && len(history) == 0) {
+		return nil
+	}
+	if _, err = action.NewUninstall(cfg).Run(name); err != nil {
+		return c.releaseError(cfg, "uninstall", name, namespace, err)
+	}
+	return nil
+}
+
+// Status returns the latest revision of the release, nil when it is not installed
+func (c *Client) Status(name string, namespace string) (rel *release.Release, err error) {
+	cfg, err := c.configure(namespace)
+	if err != nil {
+		return rel, err
+	}
+	history, err := cfg.Releases.History(name)
+	if err == driver.ErrReleaseNotFound {
+		return nil, nil
+	}
+	return latest(history), err
+}
+
+// releaseError records the state the failed action left the release in
+func (c *Client) releaseError(cfg *action.Configuration, actionName string, name string, namespace string,
+	err error) error {
+	releaseErr := &ReleaseError{Action: actionName, Release: name, Namespace: namespace, Err: err}
+	if history, historyErr := cfg.Releases.History(name); historyErr == nil {
+		if last := latest(history); last != nil && last.Info != nil {
+			releaseErr.Status = last.Info.Status
+		}
+	}
+	return releaseErr
+}
+
+func latest(history []*release.Release) (last *release.Release) {
+	for _, rel := range history {
+		if last == nil || rel.Version > last.Version {
+			last = rel
+		}
+	}
+	return last
+}
+
+func deployedBefore(history []*release.Release) bool {
+	return lastDeployed(history) != nil
+}
+
+func lastDeployed(history []*release.Release) (last *release.Release) {
+	for _, rel := range history {
+		if rel.Info != nil && (rel.Info.Status == release.StatusDeployed || rel.Info.Status == release.StatusSuperseded) &&
+			(last == nil || rel.Version > last.Version) {
+			last = rel
+		}
+	}
+	return last
+}
+
+func isPending(status release.Status) bool {
+	return status == release.StatusPendingInstall || status == release.StatusPendingUpgrade ||
+		status == release.StatusPendingRollback
+}
@@ -0,0 +1,146 @@
+package helm
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+)
+
+// bundleChart writes a chart named like the bundled one to a directory with a space in its path
+func bundleChart(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "chart path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chrt := &chart.Chart{
+		Metadata: &chart.Metadata{APIVersion: chart.APIVersionV2, Name: "kubernetes-external-secrets",
+			Version: ChartVersion},
+		Templates: []*chart.File{{Name: "templates/configmap.yaml", Data: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: external-secrets
+data:
+  vaultAddr: {{ .Values.env.VAULT_ADDR }}
+`)}},
+	}
+	if _, err = chartutil.Save(chrt, dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestInstallChart(t *testing.T) {
+	dir := bundleChart(t)
+	defer os.RemoveAll(dir)
+	os.Setenv("CHART_PATH", dir)
+	defer os.Unsetenv("CHART_PATH")
+
+	kubeClient := &kubefake.FailingKubeClient{
+		PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard},
+		CreateError:        errors.New("admission webhook denied the request"),
+	}
+	client := NewFakeClient(kubeClient)
+	w := &Wrapper{Namespace: "external-secrets", ServiceAccount: "external-secrets",
+		VaultAddress: "https://vault:8200", MountName: "k8s-demo", RoleName: "demo"}
+
+	_, err := w.InstallChart(client)
+	releaseErr, ok := err.(*ReleaseError)
+	if !ok || releaseErr.Action != "install" || releaseErr.Status != release.StatusFailed {
+		t.Fatalf("expected a failed install, got %v", err)
+	}
+
+	// a release which never deployed is installed again
+	kubeClient.CreateError = nil
+	rel, err := w.InstallChart(client)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rel.Version != 1 || rel.Info.Status != release.StatusDeployed || rel.Namespace != "external-secrets" {
+		t.Fatalf("unexpected release %s revision %d status %s", rel.Name, rel.Version, rel.Info.Status)
+	}
+
+	w.VaultAddress = "https://vault-2:8200"
+	rel, err = w.InstallChart(client)
+	if err != nil || rel.Version != 2 {
+		t.Fatalf("expected an upgrade to revision 2, got %v", err)
+	}
+	if rel.Config["env"].(map[string]interface{})["VAULT_ADDR"] != "https://vault-2:8200" {
+		t.Fatalf("upgrade did not pass the new values: %v", rel.Config)
+	}
+
+	if err = w.UninstallChart(client); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rel, err = client.Status(ReleaseName, w.Namespace); err != nil || rel != nil {
+		t.Fatalf("release still present after uninstall: %v %v", rel, err)
+	}
+	if err = w.UninstallChart(client); err != nil {
+		t.Fatalf("uninstalling a missing release failed: %v", err)
+	}
+}
+
+func TestInstallChartRecoversPendingRelease(t *testing.T) {
+	dir := bundleChart(t)
+	defer os.RemoveAll(dir)
+	os.Setenv("CHART_PATH", dir)
+	defer os.Unsetenv("CHART_PATH")
+
+	client := NewFakeClient(nil)
+	w := &Wrapper{Namespace: "external-secrets", ServiceAccount: "external-secrets",
+		VaultAddress: "https://vault:8200", MountName: "k8s-demo", RoleName: "demo"}
+	deployed, err := w.InstallChart(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the operator stopped in the middle of an upgrade
+	cfg, _ := client.configure(w.Namespace)
+	pending := *deployed
+	pending.Info = &release.Info{Status: release.StatusPendingUpgrade, LastDeployed: helmtime.Now()}
+	pending.Version = 2
+	if err = cfg.Releases.Create(&pending); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.InstallChart(client)
+	if releaseErr, ok := err.(*ReleaseError); !ok || releaseErr.Status != release.StatusPendingUpgrade {
+		t.Fatalf("expected a recent pending release to be waited for, got %v", err)
+	}
+
+	pending.Info.LastDeployed = helmtime.Time{Time: time.Now().Add(-DefaultPendingTimeout)}
+	if err = cfg.Releases.Update(&pending); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := w.InstallChart(client)
+	if err != nil {
+		t.Fatalf("stale pending release not recovered: %v", err)
+	}
+	// revision 3 rolled back to the deployed revision before upgrading
+	if rel.Version != 4 || rel.Info.Status != release.StatusDeployed {
+		t.Fatalf("unexpected release revision %d status %s", rel.Version, rel.Info.Status)
+	}
+	if stale, _ := cfg.Releases.Get(ReleaseName, 2); stale.Info.Status != release.StatusFailed {
+		t.Fatalf("stale revision left %s", stale.Info.Status)
+	}
+
+	// an install which never completed is removed and installed again
+	if err = w.UninstallChart(client); err != nil {
+		t.Fatal(err)
+	}
+	pending.Version = 1
+	pending.Info = &release.Info{Status: release.StatusPendingInstall,
+		LastDeployed: helmtime.Time{Time: time.Now().Add(-DefaultPendingTimeout)}}
+	if err = cfg.Releases.Create(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if rel, err = w.InstallChart(client); err != nil || rel.Version != 1 || rel.Info.Status != release.StatusDeployed {
+		t.Fatalf("stale pending install not replaced: %v", err)
+	}
+}
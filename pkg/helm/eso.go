@@ -0,0 +1,88 @@
+package helm
+
+import (
+	"bytes"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	ESOReleaseName = "glue-external-secrets-operator"
+	ESOChartName   = "external-secrets"
+	ESOAPIVersion  = "external-secrets.io/v1beta1"
+	// ESOValuesYaml runs a single operator, and its CRDs, for the stores of every Register
+	ESOValuesYaml = `
+installCRDs: true
+`
+	SecretStoreKind        = "SecretStore"
+	ClusterSecretStoreKind = "ClusterSecretStore"
+)
+
+// ESOChartVersion variable is passed via build flags when a new version is available
+var ESOChartVersion = "0.9.20"
+
+// SecretStoreOptions configure the store generated for the external secrets operator
+type SecretStoreOptions struct {
+	// Kind is SecretStore or ClusterSecretStore
+	Kind string
+	Name string
+	// Path is the mount of the KV engine and Version its version, v1 or v2
+	Path    string
+	Version string
+}
+
+// esoBackend installs the external secrets operator once and a store per Register using its auth mount
+type esoBackend struct{}
+
+func (esoBackend) Name() string         { return BackendExternalSecretsOperator }
+func (esoBackend) ReleaseName() string  { return ESOReleaseName }
+func (esoBackend) ChartName() string    { return ESOChartName }
+func (esoBackend) ChartVersion() string { return ESOChartVersion }
+func (esoBackend) Shared() bool         { return true }
+
+func (esoBackend) Values(w *Wrapper) (bytes.Buffer, error) {
+	return renderValues("ESOValuesYaml", ESOValuesYaml, w)
+}
+
+func (esoBackend) ProtectedValues() []string {
+	return []string{"installCRDs"}
+}
+
+// Objects returns the store logging in to vault as the service account bound to the role
+func (esoBackend) Objects(w *Wrapper) (objects []*unstructured.Unstructured, err error) {
+	serviceAccountRef := map[string]interface{}{"name": w.ServiceAccount}
+	vault := map[string]interface{}{
+		"server":  w.VaultAddress,
+		"path":    w.SecretStore.Path,
+		"version": w.SecretStore.Version,
+		"auth": map[string]interface{}{
+			"kubernetes": map[string]interface{}{
+				"mountPath":         w.MountName,
+				"role":              w.RoleName,
+				"serviceAccountRef": serviceAccountRef,
+			},
+		},
+	}
+	if len(w.VaultNamespace) != 0 {
+		vault["namespace"] = w.VaultNamespace
+	}
+	if w.VaultCACert {
+		vault["caProvider"] = map[string]interface{}{"type": "Secret", "name": "vault-ca", "key": "ca.pem"}
+	}
+
+	store := &unstructured.Unstructured{}
+	store.SetAPIVersion(ESOAPIVersion)
+	store.SetKind(w.SecretStore.Kind)
+	store.SetName(w.SecretStore.Name)
+	if w.SecretStore.Kind == ClusterSecretStoreKind {
+		// references from a cluster store must name their namespace
+		serviceAccountRef["namespace"] = w.Namespace
+		if caProvider, ok := vault["caProvider"].(map[string]interface{}); ok {
+			caProvider["namespace"] = w.Namespace
+		}
+	} else {
+		store.SetNamespace(w.Namespace)
+	}
+	err = unstructured.SetNestedMap(store.Object, map[string]interface{}{"vault": vault}, "spec", "provider")
+	return append(objects, store), err
+}
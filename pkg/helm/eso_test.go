@@ -0,0 +1,59 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestESOBackend(t *testing.T) {
+	backend, err := GetBackend(BackendExternalSecretsOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Wrapper{Namespace: "apps", ServiceAccount: "external-secrets", VaultAddress: "https://vault:8200",
+		VaultCACert: true, MountName: "k8s-demo", RoleName: "demo", Backend: backend,
+		SecretStore: SecretStoreOptions{Kind: SecretStoreKind, Name: "vault", Path: "secret", Version: "v2"}}
+
+	// one operator serves the stores of every Register
+	values, err := w.Values()
+	if err != nil || !strings.Contains(values, "installCRDs: true") || strings.Contains(values, "apps") {
+		t.Fatalf("expected cluster wide values: %q %v", values, err)
+	}
+	w.SharedNamespace = "vault-glue"
+	if namespace := w.ReleaseNamespace(); namespace != "vault-glue" {
+		t.Fatalf("shared chart installed into %s", namespace)
+	}
+	if source := w.chartSource(); source.bundled != BundledChart(ESOChartName, ESOChartVersion) {
+		t.Fatalf("unexpected bundled chart %s", source.bundled)
+	}
+
+	objects, err := w.Objects()
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("expected one store, got %v %v", objects, err)
+	}
+	store := objects[0]
+	if store.GetKind() != SecretStoreKind || store.GetNamespace() != "apps" || store.GetName() != "vault" {
+		t.Fatalf("unexpected store %s %s/%s", store.GetKind(), store.GetNamespace(), store.GetName())
+	}
+	role, _, _ := unstructured.NestedString(store.Object, "spec", "provider", "vault", "auth", "kubernetes", "role")
+	mount, _, _ := unstructured.NestedString(store.Object, "spec", "provider", "vault", "auth", "kubernetes", "mountPath")
+	if role != "demo" || mount != "k8s-demo" {
+		t.Fatalf("store does not use the auth mount: role %s mount %s", role, mount)
+	}
+
+	w.SecretStore.Kind = ClusterSecretStoreKind
+	objects, _ = w.Objects()
+	store = objects[0]
+	namespace, _, _ := unstructured.NestedString(store.Object,
+		"spec", "provider", "vault", "auth", "kubernetes", "serviceAccountRef", "namespace")
+	caNamespace, _, _ := unstructured.NestedString(store.Object, "spec", "provider", "vault", "caProvider", "namespace")
+	if store.GetNamespace() != "" || namespace != "apps" || caNamespace != "apps" {
+		t.Fatalf("cluster store references must name their namespace: %v", store.Object)
+	}
+
+	if _, err = GetBackend("Unknown"); err == nil {
+		t.Fatal("expected an unknown backend to be rejected")
+	}
+}
@@ -0,0 +1,32 @@
+package helm
+
+import (
+	"io/ioutil"
+
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+)
+
+// NewFakeClient returns a client keeping releases in memory. Manifests are handed to kubeClient,
+// which defaults to a client accepting everything without a cluster
+func NewFakeClient(kubeClient kube.Interface) *Client {
+	if kubeClient == nil {
+		kubeClient = &kubefake.PrintingKubeClient{Out: ioutil.Discard}
+	}
+	memory := driver.NewMemory()
+	c := &Client{Log: func(format string, v ...interface{}) {}, PendingTimeout: DefaultPendingTimeout}
+	c.configure = func(namespace string) (*action.Configuration, error) {
+		memory.SetNamespace(namespace)
+		return &action.Configuration{
+			Releases:     storage.Init(memory),
+			KubeClient:   kubeClient,
+			Capabilities: chartutil.DefaultCapabilities,
+			Log:          c.Log,
+		}, nil
+	}
+	return c
+}
@@ -3,32 +3,46 @@ package helm
 import (
 	"bytes"
 	"fmt"
-	"html/template"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 type Wrapper struct {
 	Namespace       string
 	ServiceAccount  string
 	VaultAddress    string
+	VaultNamespace  string
 	VaultSkipVerify bool
 	VaultCACert     bool
 	MountName       string
 	RoleName        string
+	Source          ChartSource
+	// Backend defaults to kubernetes-external-secrets
+	Backend             Backend
+	SecretStore         SecretStoreOptions
+	SecretProviderClass SecretProviderClassOptions
+	// ValueOverrides are merged over the generated values in order
+	ValueOverrides []map[string]interface{}
+	// OverrideProtected lists the protected values of the backend the overrides may replace
+	OverrideProtected []string
+	// SharedNamespace holds the release of shared backends, the operator namespace
+	SharedNamespace string
 }
 
 // ChartVersion variable is passed via build flags when a new version is available
 var ChartVersion = "6.1.0"
 
 const (
-	HelmCommand = "helm"
+	ReleaseName = "glue-external-secrets"
 	ChartPath   = "/data/"
 	ValuesYaml  = `
 env:
   VAULT_ADDR: {{ .VaultAddress }}
+  {{if .VaultNamespace -}}VAULT_NAMESPACE: {{ .VaultNamespace }}{{- end}}
   {{if .VaultCACert -}}NODE_EXTRA_CA_CERTS: "/usr/local/share/ca-certificates/ca.pem"{{- end}}
   VAULT_SKIP_VERIFY: {{ .VaultSkipVerify }}
   DEFAULT_VAULT_MOUNT_POINT: {{ .MountName }}
@@ -48,50 +62,88 @@ serviceAccount:
 )
 
 // InstallChart is used by the operator to manage helm chart install for external secrets
-func (w *Wrapper) InstallChart() (cmdOutput []byte, err error) {
-	chartPath, ok := os.LookupEnv("CHART_PATH")
-	if !ok {
-		chartPath = ChartPath
+func (w *Wrapper) InstallChart(client *Client) (rel *release.Release, err error) {
+	source := w.chartSource()
+	chartPath, err := source.Fetch()
+	if err != nil {
+		return rel, err
 	}
-	output, err := w.generateValues()
+	chrt, err := loader.Load(chartPath)
 	if err != nil {
-		return cmdOutput, err
+		return rel, err
 	}
-
-	tmpValues, err := ioutil.TempFile("/tmp", "values")
+	values, err := w.chartValues()
 	if err != nil {
-		return cmdOutput, err
+		return rel, err
 	}
+	return client.InstallOrUpgrade(w.backend().ReleaseName(), w.ReleaseNamespace(), chrt, values)
+}
+
+// ReleaseNamespace is the namespace of the Register, or the shared namespace for shared backends
+func (w *Wrapper) ReleaseNamespace() string {
+	if w.backend().Shared() {
+		return w.SharedNamespace
+	}
+	return w.Namespace
+}
+
+// ChartReference identifies the chart of the backend, changing it upgrades the release
+func (w *Wrapper) ChartReference() string {
+	source := w.chartSource()
+	return source.Reference()
+}
 
-	if _, err = fmt.Fprintln(tmpValues, output.String()); err != nil {
-		return cmdOutput, err
+// Objects returns the objects of the backend applied next to the chart
+func (w *Wrapper) Objects() (objects []*unstructured.Unstructured, err error) {
+	return w.backend().Objects(w)
+}
+
+func (w *Wrapper) backend() Backend {
+	if w.Backend == nil {
+		backend, _ := GetBackend("")
+		return backend
 	}
+	return w.Backend
+}
 
-	if err = tmpValues.Close(); err != nil {
-		return cmdOutput, err
+// chartSource defaults the chart name and version to the bundled chart of the backend
+func (w *Wrapper) chartSource() ChartSource {
+	backend := w.backend()
+	source := w.Source
+	if len(source.Name) == 0 {
+		source.Name = backend.ChartName()
 	}
+	if len(source.Version) == 0 {
+		source.Version = backend.ChartVersion()
+	}
+	source.bundled = BundledChart(backend.ChartName(), backend.ChartVersion())
+	return source
+}
 
-	defer os.Remove(tmpValues.Name())
+// ChartDir holds the bundled charts, read from $CHART_PATH
+func ChartDir() string {
+	chartPath, ok := os.LookupEnv("CHART_PATH")
+	if !ok {
+		chartPath = ChartPath
+	}
+	return filepath.Clean(chartPath)
+}
 
-	installArgsStr := fmt.Sprintf("upgrade --install glue-external-secrets %s/kubernetes-external-secrets-%s.tgz -n %s  -f %s",
-		chartPath, ChartVersion, w.Namespace, tmpValues.Name())
-	installArgs := strings.Fields(installArgsStr)
-	helmCommand := exec.Command(HelmCommand, installArgs...)
-	cmdOutput, err = helmCommand.CombinedOutput()
-	return cmdOutput, err
+// BundledChart is the chart tarball of the given version shipped in the image
+func BundledChart(name string, version string) string {
+	return filepath.Join(ChartDir(), fmt.Sprintf("%s-%s.tgz", name, version))
 }
 
 func (w *Wrapper) generateValues() (output bytes.Buffer, err error) {
-	valuesTemplate := template.Must(template.New("ValuesYaml").Parse(ValuesYaml))
-	err = valuesTemplate.Execute(&output, w)
-	return output, err
+	return w.backend().Values(w)
+}
+
+// ReleaseStatus returns the latest revision of the release, nil when it is not installed
+func (w *Wrapper) ReleaseStatus(client *Client) (rel *release.Release, err error) {
+	return client.Status(w.backend().ReleaseName(), w.ReleaseNamespace())
 }
 
 // UninstallChart is the used by the operator to clean up the helm chart
-func (w *Wrapper) UninstallChart() (cmdOutput []byte, err error) {
-	uninstallArgsStr := fmt.Sprintf("uninstall glue-external-secrets -n %s", w.Namespace)
-	uninstallArgs := strings.Fields(uninstallArgsStr)
-	helmCommand := exec.Command(HelmCommand, uninstallArgs...)
-	cmdOutput, err = helmCommand.CombinedOutput()
-	return cmdOutput, err
+func (w *Wrapper) UninstallChart(client *Client) (err error) {
+	return client.Uninstall(w.backend().ReleaseName(), w.ReleaseNamespace())
 }
@@ -0,0 +1,409 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/downloader"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultChartName = "kubernetes-external-secrets"
+	ociScheme        = "oci://"
+)
+
+// chart layer media types written by helm chart push and helm push
+var ociChartMediaTypes = []string{"application/vnd.cncf.helm.chart.content.v1.tar+gzip", "application/tar+gzip"}
+
+// ChartSource selects the chart archive to install. The zero value is the chart bundled
+// with the operator, which needs no network access
+type ChartSource struct {
+	// Path is a chart tarball on the operator filesystem
+	Path string
+	// Repository is an HTTP chart repository, or an OCI reference starting with oci://
+	Repository string
+	// Name of the chart in an HTTP repository, defaults to kubernetes-external-secrets
+	Name string
+	// Version defaults to the bundled chart version
+	Version  string
+	Username string
+	Password string
+	// CACert is a PEM bundle used to verify the repository
+	CACert []byte
+	// Digest is the expected sha256 of the chart archive, as sha256:<hex>
+	Digest string
+	// Keyring is a public keyring the provenance file of the chart must verify against
+	Keyring []byte
+	// CacheDir keeps downloaded charts so they are only fetched once per version
+	CacheDir string
+	// bundled is the chart shipped in the image, used without a path or repository
+	bundled string
+}
+
+// Reference identifies the chart, changing it upgrades the release. Empty for the bundled chart
+func (s *ChartSource) Reference() string {
+	switch {
+	case len(s.Repository) != 0:
+		return fmt.Sprintf("%s %s@%s %s", s.Repository, s.name(), s.version(), s.Digest)
+	case len(s.Path) != 0:
+		return fmt.Sprintf("%s %s", s.Path, s.Digest)
+	}
+	return s.Digest
+}
+
+// Fetch returns the path of the chart archive, downloading it into the cache when it is not there yet
+func (s *ChartSource) Fetch() (chartPath string, err error) {
+	switch {
+	case len(s.Repository) != 0:
+		chartPath, err = s.fetchRemote()
+	case len(s.Path) != 0:
+		chartPath = s.Path
+	case len(s.bundled) != 0:
+		chartPath = s.bundled
+	default:
+		chartPath = BundledChart(s.name(), s.version())
+	}
+	if err != nil {
+		return chartPath, err
+	}
+	if err = s.verifyDigest(chartPath); err != nil {
+		return chartPath, err
+	}
+	if len(s.Keyring) != 0 {
+		err = s.verifyProvenance(chartPath)
+	}
+	return chartPath, err
+}
+
+func (s *ChartSource) fetchRemote() (chartPath string, err error) {
+	if len(s.CacheDir) == 0 {
+		return chartPath, fmt.Errorf("no chart cache directory configured")
+	}
+	sum := sha256.Sum256([]byte(s.Repository))
+	dir := filepath.Join(s.CacheDir, hex.EncodeToString(sum[:8]))
+	chartPath = filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", s.name(), s.version()))
+	if _, err = os.Stat(chartPath); err == nil {
+		// a cached chart which no longer matches the digest is downloaded again
+		if s.verifyDigest(chartPath) == nil {
+			return chartPath, nil
+		}
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return chartPath, err
+	}
+
+	client, err := s.httpClient()
+	if err != nil {
+		return chartPath, err
+	}
+	if strings.HasPrefix(s.Repository, ociScheme) {
+		if len(s.Keyring) != 0 {
+			return chartPath, fmt.Errorf("provenance verification is not supported for OCI charts, use a digest")
+		}
+		return chartPath, s.pullOCI(client, chartPath)
+	}
+
+	chartURL, err := s.findChart(client)
+	if err != nil {
+		return chartPath, err
+	}
+	if err = s.download(client, chartURL, chartPath); err != nil {
+		return chartPath, err
+	}
+	if len(s.Keyring) != 0 {
+		err = s.download(client, chartURL+".prov", chartPath+".prov")
+	}
+	return chartPath, err
+}
+
+// findChart looks up the chart URL in the index of the HTTP repository
+func (s *ChartSource) findChart(client *http.Client) (chartURL string, err error) {
+	indexURL := strings.TrimSuffix(s.Repository, "/") + "/index.yaml"
+	resp, err := s.get(client, indexURL)
+	if err != nil {
+		return chartURL, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return chartURL, err
+	}
+	index := &repo.IndexFile{}
+	if err = yaml.Unmarshal(data, index); err != nil {
+		return chartURL, fmt.Errorf("invalid index in chart repository %s: %v", s.Repository, err)
+	}
+	version, err := index.Get(s.name(), s.version())
+	if err != nil || len(version.URLs) == 0 {
+		return chartURL, fmt.Errorf("chart %s version %s not found in repository %s", s.name(), s.version(), s.Repository)
+	}
+	return repo.ResolveReferenceURL(s.Repository, version.URLs[0])
+}
+
+// pullOCI downloads the chart layer of an OCI artifact. The blob is checked against its digest
+func (s *ChartSource) pullOCI(client *http.Client, chartPath string) (err error) {
+	registry, repository, reference := parseOCIReference(strings.TrimPrefix(s.Repository, ociScheme), s.version())
+	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, reference)
+	req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.oci.image.manifest.v1+json")
+	resp, err := s.doRegistry(client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	manifest := struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}{}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		err = yaml.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid manifest for %s: %v", s.Repository, err)
+	}
+
+	for _, layer := range manifest.Layers {
+		for _, mediaType := range ociChartMediaTypes {
+			if layer.MediaType != mediaType {
+				continue
+			}
+			blobURL := fmt.Sprintf("https://%s/v2/%s/blobs/%s", registry, repository, layer.Digest)
+			if err = s.download(client, blobURL, chartPath); err != nil {
+				return err
+			}
+			if err = checkDigest(chartPath, layer.Digest); err != nil {
+				os.Remove(chartPath)
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("no chart layer found in %s", s.Repository)
+}
+
+// parseOCIReference splits registry/repository[:tag|@digest], falling back to version as the tag
+func parseOCIReference(ref string, version string) (registry string, repository string, reference string) {
+	parts := strings.SplitN(ref, "/", 2)
+	registry = parts[0]
+	if len(parts) == 2 {
+		repository = parts[1]
+	}
+	reference = version
+	if i := strings.Index(repository, "@"); i != -1 {
+		return registry, repository[:i], repository[i+1:]
+	}
+	if i := strings.LastIndex(repository, ":"); i != -1 {
+		return registry, repository[:i], repository[i+1:]
+	}
+	return registry, repository, reference
+}
+
+// doRegistry sends the request, answering a bearer token challenge of the registry once
+func (s *ChartSource) doRegistry(client *http.Client, req *http.Request) (resp *http.Response, err error) {
+	if len(s.Username) != 0 {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	resp, err = client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, checkResponse(resp, err)
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return nil, fmt.Errorf("%s: unauthorized", req.URL)
+	}
+
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	tokenReq, err := http.NewRequest(http.MethodGet, params["realm"], nil)
+	if err != nil {
+		return nil, err
+	}
+	query := tokenReq.URL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if len(params[key]) != 0 {
+			query.Set(key, params[key])
+		}
+	}
+	tokenReq.URL.RawQuery = query.Encode()
+	if len(s.Username) != 0 {
+		tokenReq.SetBasicAuth(s.Username, s.Password)
+	}
+	tokenResp, err := client.Do(tokenReq)
+	if err = checkResponse(tokenResp, err); err != nil {
+		return nil, err
+	}
+	defer tokenResp.Body.Close()
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	data, err := ioutil.ReadAll(tokenResp.Body)
+	if err == nil {
+		err = yaml.Unmarshal(data, &token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(token.Token) == 0 {
+		token.Token = token.AccessToken
+	}
+
+	retry, err := http.NewRequest(req.Method, req.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	retry.Header = req.Header.Clone()
+	retry.Header.Set("Authorization", "Bearer "+token.Token)
+	resp, err = client.Do(retry)
+	return resp, checkResponse(resp, err)
+}
+
+func (s *ChartSource) get(client *http.Client, url string) (resp *http.Response, err error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(s.Repository, ociScheme) {
+		return s.doRegistry(client, req)
+	}
+	// an index may point at charts on other hosts, which must never see the credentials
+	if len(s.Username) != 0 && s.repositoryHost(req.URL) {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	resp, err = client.Do(req)
+	return resp, checkResponse(resp, err)
+}
+
+// repositoryHost is true when target has the scheme and host of the repository
+func (s *ChartSource) repositoryHost(target *url.URL) bool {
+	repository, err := url.Parse(s.Repository)
+	return err == nil && strings.EqualFold(repository.Scheme, target.Scheme) &&
+		strings.EqualFold(repository.Host, target.Host)
+}
+
+// download writes url to path through a temporary file, so a partial download is never cached
+func (s *ChartSource) download(client *http.Client, url string, path string) (err error) {
+	resp, err := s.get(client, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *ChartSource) httpClient() (client *http.Client, err error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(s.CACert) != 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(s.CACert) {
+			return client, fmt.Errorf("no PEM encoded certificate found in the chart repository CA")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func (s *ChartSource) verifyDigest(chartPath string) error {
+	if len(s.Digest) == 0 {
+		return nil
+	}
+	return checkDigest(chartPath, s.Digest)
+}
+
+// verifyProvenance checks the .prov file next to the chart against the keyring
+func (s *ChartSource) verifyProvenance(chartPath string) (err error) {
+	keyring, err := ioutil.TempFile("", "keyring")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(keyring.Name())
+	_, err = keyring.Write(s.Keyring)
+	if closeErr := keyring.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = downloader.VerifyChart(chartPath, keyring.Name()); err != nil {
+		return fmt.Errorf("provenance of chart %s could not be verified: %v", filepath.Base(chartPath), err)
+	}
+	return nil
+}
+
+func (s *ChartSource) name() string {
+	if len(s.Name) == 0 {
+		return DefaultChartName
+	}
+	return s.Name
+}
+
+func (s *ChartSource) version() string {
+	if len(s.Version) == 0 {
+		return ChartVersion
+	}
+	return s.Version
+}
+
+func checkDigest(path string, digest string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return err
+	}
+	actual := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	if actual != digest {
+		return fmt.Errorf("chart %s has digest %s, expected %s", filepath.Base(path), actual, digest)
+	}
+	return nil
+}
+
+func checkResponse(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL, resp.Status)
+	}
+	return nil
+}
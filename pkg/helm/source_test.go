@@ -0,0 +1,188 @@
+package helm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func chartArchive(t *testing.T) (data []byte, digest string) {
+	dir := bundleChart(t)
+	defer os.RemoveAll(dir)
+	data, err := ioutil.ReadFile(archiveIn(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return data, "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// archiveIn is the archive bundleChart wrote to dir
+func archiveIn(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s-%s.tgz", DefaultChartName, ChartVersion))
+}
+
+func serverCA(server *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+}
+
+func TestFetchFromRepository(t *testing.T) {
+	archive, digest := chartArchive(t)
+	var downloads int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, _ := req.BasicAuth(); user != "reader" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/charts/index.yaml":
+			fmt.Fprintf(w, `apiVersion: v1
+entries:
+  %s:
+  - name: %s
+    version: %s
+    urls:
+    - %s-%s.tgz
+`, DefaultChartName, DefaultChartName, ChartVersion, DefaultChartName, ChartVersion)
+		case "/charts/" + filepath.Base(archiveIn("")):
+			downloads++
+			_, _ = w.Write(archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	cacheDir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	source := &ChartSource{Repository: server.URL + "/charts", Username: "reader", Password: "secret",
+		CACert: serverCA(server), Digest: digest, CacheDir: cacheDir}
+	chartPath, err := source.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if downloads != 1 || !strings.HasPrefix(chartPath, cacheDir) {
+		t.Fatalf("chart not downloaded into the cache: %s", chartPath)
+	}
+
+	// cached charts are served without the repository
+	server.Close()
+	if _, err = source.Fetch(); err != nil {
+		t.Fatalf("cached chart not used: %v", err)
+	}
+
+	source.Digest = "sha256:" + strings.Repeat("0", 64)
+	if _, err = source.Fetch(); err == nil {
+		t.Fatalf("expected a digest mismatch to fail")
+	}
+}
+
+func TestCredentialsOnlySentToRepository(t *testing.T) {
+	archive, _ := chartArchive(t)
+	var leaked bool
+	mirror := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _, leaked = req.BasicAuth()
+		_, _ = w.Write(archive)
+	}))
+	defer mirror.Close()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, _ := req.BasicAuth(); user != "reader" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the index of the repository points at a chart on another host
+		fmt.Fprintf(w, `apiVersion: v1
+entries:
+  %s:
+  - name: %s
+    version: %s
+    urls:
+    - %s/%s-%s.tgz
+`, DefaultChartName, DefaultChartName, ChartVersion, mirror.URL, DefaultChartName, ChartVersion)
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	// both test servers share the same certificate
+	source := &ChartSource{Repository: server.URL, Username: "reader", Password: "secret",
+		CACert: serverCA(server), CacheDir: cacheDir}
+	if _, err = source.Fetch(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if leaked {
+		t.Fatal("repository credentials sent to another host")
+	}
+}
+
+func TestFetchFromOCIRegistry(t *testing.T) {
+	archive, digest := chartArchive(t)
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			if user, _, _ := req.BasicAuth(); user != "robot" || req.URL.Query().Get("scope") != "repository:charts/external-secrets:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token":"pull-token"}`))
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry",scope="repository:charts/external-secrets:pull"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/v2/charts/external-secrets/manifests/" + ChartVersion:
+			fmt.Fprintf(w, `{"schemaVersion":2,"layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip","digest":"%s"}]}`, digest)
+		case "/v2/charts/external-secrets/blobs/" + digest:
+			_, _ = w.Write(archive)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	source := &ChartSource{Repository: "oci://" + strings.TrimPrefix(server.URL, "https://") + "/charts/external-secrets",
+		Username: "robot", Password: "secret", CACert: serverCA(server), CacheDir: cacheDir}
+	chartPath, err := source.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(chartPath); string(data) != string(archive) {
+		t.Fatalf("pulled chart does not match the pushed archive")
+	}
+}
+
+func TestParseOCIReference(t *testing.T) {
+	tests := map[string][3]string{
+		"ghcr.io/charts/eso":            {"ghcr.io", "charts/eso", "1.0.0"},
+		"ghcr.io/charts/eso:2.0.0":      {"ghcr.io", "charts/eso", "2.0.0"},
+		"localhost:5000/eso@sha256:abc": {"localhost:5000", "eso", "sha256:abc"},
+	}
+	for ref, want := range tests {
+		registry, repository, reference := parseOCIReference(ref, "1.0.0")
+		if [3]string{registry, repository, reference} != want {
+			t.Errorf("parseOCIReference(%s) = %s %s %s, want %v", ref, registry, repository, reference, want)
+		}
+	}
+}
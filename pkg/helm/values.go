@@ -0,0 +1,119 @@
+package helm
+
+import (
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// chartValues are the generated values with the overrides merged over them in order.
+// Protected values are reset to the generated ones unless listed in OverrideProtected
+func (w *Wrapper) chartValues() (values chartutil.Values, err error) {
+	output, err := w.generateValues()
+	if err != nil {
+		return values, err
+	}
+	generated, err := chartutil.ReadValues(output.Bytes())
+	if err != nil {
+		return values, err
+	}
+	values = generated
+	for _, override := range w.ValueOverrides {
+		values = mergeValues(values, override)
+	}
+	if len(w.ValueOverrides) == 0 {
+		return values, nil
+	}
+
+	for _, path := range w.backend().ProtectedValues() {
+		if containsValue(w.OverrideProtected, path) {
+			continue
+		}
+		keys := strings.Split(path, ".")
+		if value, ok := lookupValue(generated, keys); ok {
+			setValue(values, keys, value)
+		} else {
+			deleteValue(values, keys)
+		}
+	}
+	return values, nil
+}
+
+// Values returns the values passed to the chart
+func (w *Wrapper) Values() (values string, err error) {
+	if len(w.ValueOverrides) == 0 {
+		// the rendered template is kept as is so existing releases are not upgraded
+		output, err := w.generateValues()
+		return output.String(), err
+	}
+	merged, err := w.chartValues()
+	if err != nil {
+		return values, err
+	}
+	output, err := yaml.Marshal(merged)
+	return string(output), err
+}
+
+// mergeValues returns src deep merged over dst. Maps are merged, any other value of
+// src replaces the one in dst. Neither dst nor src are modified
+func mergeValues(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(dst))
+	for key, value := range dst {
+		merged[key] = value
+	}
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := merged[key].(map[string]interface{}); ok {
+				merged[key] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+func lookupValue(values map[string]interface{}, keys []string) (value interface{}, ok bool) {
+	for i, key := range keys {
+		if value, ok = values[key]; !ok || i == len(keys)-1 {
+			return value, ok
+		}
+		if values, ok = value.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// setValue and deleteValue copy the maps along the path as they may be shared with the
+// generated values
+func setValue(values map[string]interface{}, keys []string, value interface{}) {
+	for _, key := range keys[:len(keys)-1] {
+		child, _ := values[key].(map[string]interface{})
+		values[key] = mergeValues(child, nil)
+		values = values[key].(map[string]interface{})
+	}
+	values[keys[len(keys)-1]] = value
+}
+
+func deleteValue(values map[string]interface{}, keys []string) {
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := values[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		values[key] = mergeValues(child, nil)
+		values = values[key].(map[string]interface{})
+	}
+	delete(values, keys[len(keys)-1])
+}
+
+func containsValue(paths []string, path string) bool {
+	for _, item := range paths {
+		if item == path {
+			return true
+		}
+	}
+	return false
+}
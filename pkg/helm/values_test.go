@@ -0,0 +1,57 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChartValuesOverrides(t *testing.T) {
+	w := &Wrapper{Namespace: "apps", ServiceAccount: "external-secrets", VaultAddress: "https://vault:8200",
+		MountName: "k8s-demo", RoleName: "demo"}
+	rendered, err := w.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(rendered, "DEFAULT_VAULT_MOUNT_POINT: k8s-demo") {
+		t.Fatalf("rendered values expected without overrides: %s", rendered)
+	}
+
+	w.ValueOverrides = []map[string]interface{}{
+		{"replicaCount": 2, "env": map[string]interface{}{"LOG_LEVEL": "debug", "VAULT_ADDR": "https://other:8200"}},
+		{"replicaCount": 3, "env": map[string]interface{}{"VAULT_NAMESPACE": "team-a"},
+			"serviceAccount": "replaced"},
+	}
+	values, err := w.chartValues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replicas, _ := values.PathValue("replicaCount"); replicas != 3 {
+		t.Fatalf("later overrides must take precedence: %v", replicas)
+	}
+	if level, _ := values.PathValue("env.LOG_LEVEL"); level != "debug" {
+		t.Fatalf("overrides not merged into env: %v", values)
+	}
+	if addr, _ := values.PathValue("env.VAULT_ADDR"); addr != "https://vault:8200" {
+		t.Fatalf("protected value replaced: %v", addr)
+	}
+	if mount, _ := values.PathValue("env.DEFAULT_VAULT_MOUNT_POINT"); mount != "k8s-demo" {
+		t.Fatalf("generated value lost in the merge: %v", mount)
+	}
+	if _, err := values.PathValue("env.VAULT_NAMESPACE"); err == nil {
+		t.Fatalf("protected value added although the operator does not set it: %v", values)
+	}
+	if name, _ := values.PathValue("serviceAccount.name"); name != "external-secrets" {
+		t.Fatalf("protected value under a replaced map not restored: %v", values["serviceAccount"])
+	}
+
+	w.OverrideProtected = []string{"env.VAULT_ADDR"}
+	values, _ = w.chartValues()
+	if addr, _ := values.PathValue("env.VAULT_ADDR"); addr != "https://other:8200" {
+		t.Fatalf("explicit override of a protected value ignored: %v", addr)
+	}
+
+	// protecting values never modifies the overrides
+	if env := w.ValueOverrides[1]["env"].(map[string]interface{}); env["VAULT_NAMESPACE"] != "team-a" {
+		t.Fatalf("overrides modified: %v", env)
+	}
+}
@@ -0,0 +1,126 @@
+package helm
+
+import (
+	"bytes"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	VaultChartName = "vault"
+	// InjectorReleaseName and CSIReleaseName install the hashicorp vault chart without a server
+	InjectorReleaseName = "glue-vault-agent-injector"
+	CSIReleaseName      = "glue-vault-csi-provider"
+	// InjectorValuesYaml points the injector at the auth mount and limits it to pods in the namespace
+	InjectorValuesYaml = `
+fullnameOverride: glue-vault-{{ .Namespace }}
+global:
+  externalVaultAddr: {{ .VaultAddress }}
+server:
+  enabled: false
+csi:
+  enabled: false
+injector:
+  enabled: true
+  authPath: auth/{{ .MountName }}
+  namespaceSelector:
+    matchLabels:
+      kubernetes.io/metadata.name: {{ .Namespace }}
+  {{if .VaultNamespace -}}
+  extraEnvironmentVars:
+    AGENT_INJECT_VAULT_NAMESPACE: {{ .VaultNamespace }}
+  {{- end }}
+`
+	// CSIValuesYaml only runs the provider, the vault address and auth settings are part of
+	// each SecretProviderClass
+	CSIValuesYaml = `
+fullnameOverride: glue-vault-csi-provider
+server:
+  enabled: false
+injector:
+  enabled: false
+csi:
+  enabled: true
+`
+	SecretProviderClassAPIVersion = "secrets-store.csi.x-k8s.io/v1"
+	SecretProviderClassKind       = "SecretProviderClass"
+)
+
+// VaultChartVersion variable is passed via build flags when a new version is available
+var VaultChartVersion = "0.28.1"
+
+// SecretProviderClassOptions configure the SecretProviderClass generated for the CSI provider
+type SecretProviderClassOptions struct {
+	Name string
+	// Objects lists the vault secrets to mount in the format of the vault provider, the class
+	// is a template to complete when empty
+	Objects string
+}
+
+// injectorBackend installs the vault agent sidecar injector
+type injectorBackend struct{}
+
+func (injectorBackend) Name() string         { return BackendVaultAgentInjector }
+func (injectorBackend) ReleaseName() string  { return InjectorReleaseName }
+func (injectorBackend) ChartName() string    { return VaultChartName }
+func (injectorBackend) ChartVersion() string { return VaultChartVersion }
+func (injectorBackend) Shared() bool         { return false }
+
+func (injectorBackend) Values(w *Wrapper) (bytes.Buffer, error) {
+	return renderValues("InjectorValuesYaml", InjectorValuesYaml, w)
+}
+
+func (injectorBackend) ProtectedValues() []string {
+	return []string{"global.externalVaultAddr", "server.enabled", "injector.authPath",
+		"injector.namespaceSelector", "injector.extraEnvironmentVars.AGENT_INJECT_VAULT_NAMESPACE"}
+}
+
+// Objects is empty, pods pick the role with the vault.hashicorp.com/role annotation
+func (injectorBackend) Objects(w *Wrapper) ([]*unstructured.Unstructured, error) {
+	return nil, nil
+}
+
+// csiBackend installs the vault provider of the secrets store CSI driver once, as it listens
+// on a socket of each node, and a SecretProviderClass per Register
+type csiBackend struct{}
+
+func (csiBackend) Name() string         { return BackendVaultCSIProvider }
+func (csiBackend) ReleaseName() string  { return CSIReleaseName }
+func (csiBackend) ChartName() string    { return VaultChartName }
+func (csiBackend) ChartVersion() string { return VaultChartVersion }
+func (csiBackend) Shared() bool         { return true }
+
+func (csiBackend) Values(w *Wrapper) (bytes.Buffer, error) {
+	return renderValues("CSIValuesYaml", CSIValuesYaml, w)
+}
+
+func (csiBackend) ProtectedValues() []string {
+	return []string{"server.enabled", "csi.enabled"}
+}
+
+// Objects returns a SecretProviderClass logging in with the role of the Register
+func (csiBackend) Objects(w *Wrapper) (objects []*unstructured.Unstructured, err error) {
+	parameters := map[string]interface{}{
+		"vaultAddress":             w.VaultAddress,
+		"roleName":                 w.RoleName,
+		"vaultKubernetesMountPath": w.MountName,
+		"objects":                  w.SecretProviderClass.Objects,
+	}
+	if len(w.VaultNamespace) != 0 {
+		parameters["vaultNamespace"] = w.VaultNamespace
+	}
+	if w.VaultSkipVerify {
+		parameters["vaultSkipTLSVerify"] = "true"
+	}
+
+	class := &unstructured.Unstructured{}
+	class.SetAPIVersion(SecretProviderClassAPIVersion)
+	class.SetKind(SecretProviderClassKind)
+	class.SetNamespace(w.Namespace)
+	class.SetName(w.SecretProviderClass.Name)
+	err = unstructured.SetNestedMap(class.Object, map[string]interface{}{
+		"provider":   "vault",
+		"parameters": parameters,
+	}, "spec")
+	return append(objects, class), err
+}
@@ -0,0 +1,86 @@
+package helm
+
+import (
+	"strings"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestInjectorBackend(t *testing.T) {
+	backend, err := GetBackend(BackendVaultAgentInjector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Wrapper{Namespace: "apps", VaultAddress: "https://vault:8200", VaultNamespace: "team-a",
+		MountName: "k8s-demo", RoleName: "demo", Backend: backend}
+
+	values, err := w.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := chartutil.ReadValues([]byte(values))
+	if err != nil {
+		t.Fatalf("values are not valid yaml: %v\n%s", err, values)
+	}
+	if path, _ := parsed.PathValue("injector.authPath"); path != "auth/k8s-demo" {
+		t.Fatalf("injector not pointed at the auth mount: %v", path)
+	}
+	if enabled, _ := parsed.PathValue("server.enabled"); enabled != false {
+		t.Fatalf("a vault server must not be installed: %v", enabled)
+	}
+	if namespace, _ := parsed.PathValue("injector.extraEnvironmentVars.AGENT_INJECT_VAULT_NAMESPACE"); namespace != "team-a" {
+		t.Fatalf("vault namespace not passed to the injector: %v", namespace)
+	}
+	if objects, _ := w.Objects(); len(objects) != 0 {
+		t.Fatalf("unexpected objects for the injector: %v", objects)
+	}
+
+	// the injector must not be widened to the pods of other namespaces
+	w.ValueOverrides = []map[string]interface{}{{"injector": map[string]interface{}{
+		"namespaceSelector": map[string]interface{}{}}}}
+	values, err = w.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ = chartutil.ReadValues([]byte(values))
+	if labels, _ := parsed.Table("injector.namespaceSelector.matchLabels"); labels["kubernetes.io/metadata.name"] != "apps" {
+		t.Fatalf("namespace selector overridden: %s", values)
+	}
+}
+
+func TestCSIBackend(t *testing.T) {
+	backend, err := GetBackend(BackendVaultCSIProvider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &Wrapper{Namespace: "apps", VaultAddress: "https://vault:8200", VaultSkipVerify: true,
+		MountName: "k8s-demo", RoleName: "demo", Backend: backend,
+		SecretProviderClass: SecretProviderClassOptions{Name: "vault"}}
+
+	// a single provider serves the classes of every Register
+	values, err := w.Values()
+	if err != nil || !strings.Contains(values, "csi:\n  enabled: true") || strings.Contains(values, "apps") ||
+		strings.Contains(values, w.VaultAddress) {
+		t.Fatalf("expected the shared csi provider to be enabled: %q %v", values, err)
+	}
+	w.SharedNamespace = "vault-glue"
+	if namespace := w.ReleaseNamespace(); namespace != "vault-glue" {
+		t.Fatalf("shared chart installed into %s", namespace)
+	}
+
+	objects, err := w.Objects()
+	if err != nil || len(objects) != 1 {
+		t.Fatalf("expected one SecretProviderClass, got %v %v", objects, err)
+	}
+	class := objects[0]
+	if class.GetKind() != SecretProviderClassKind || class.GetNamespace() != "apps" || class.GetName() != "vault" {
+		t.Fatalf("unexpected class %s %s/%s", class.GetKind(), class.GetNamespace(), class.GetName())
+	}
+	parameters, _, _ := unstructured.NestedStringMap(class.Object, "spec", "parameters")
+	if parameters["roleName"] != "demo" || parameters["vaultKubernetesMountPath"] != "k8s-demo" ||
+		parameters["vaultAddress"] != "https://vault:8200" || parameters["vaultSkipTLSVerify"] != "true" {
+		t.Fatalf("class not pre-filled from the Register: %v", parameters)
+	}
+}
@@ -0,0 +1,100 @@
+package vault
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const (
+	AuthMethodToken      = "token"
+	AuthMethodKubernetes = "kubernetes"
+	AuthMethodAppRole    = "approle"
+)
+
+// Authenticator is used by the operator to obtain a vault token before talking to vault
+type Authenticator interface {
+	Login(client *api.Client) (token string, err error)
+}
+
+// TokenAuth uses a static token, typically sourced from a k8s secret
+type TokenAuth struct {
+	Token string
+}
+
+// KubernetesAuth logs in to vault with the JWT of the operators own service account
+type KubernetesAuth struct {
+	Mount string
+	Role  string
+	JWT   string
+}
+
+// AppRoleAuth logs in to vault using an approle role_id and secret_id
+type AppRoleAuth struct {
+	Mount    string
+	RoleID   string
+	SecretID string
+}
+
+// Login returns the static token
+func (t *TokenAuth) Login(client *api.Client) (token string, err error) {
+	if len(t.Token) == 0 {
+		return token, fmt.Errorf("vault token is empty")
+	}
+	return t.Token, nil
+}
+
+// Login performs a login against the kubernetes auth mount
+func (k *KubernetesAuth) Login(client *api.Client) (token string, err error) {
+	loginData := make(map[string]interface{})
+	loginData["role"] = k.Role
+	loginData["jwt"] = k.JWT
+	return login(client, k.Mount, loginData)
+}
+
+// Login performs a login against the approle auth mount
+func (a *AppRoleAuth) Login(client *api.Client) (token string, err error) {
+	loginData := make(map[string]interface{})
+	loginData["role_id"] = a.RoleID
+	loginData["secret_id"] = a.SecretID
+	return login(client, a.Mount, loginData)
+}
+
+func login(client *api.Client, mount string, loginData map[string]interface{}) (token string, err error) {
+	secret, err := client.Logical().Write("auth/"+mount+"/login", loginData)
+	if err != nil {
+		return token, err
+	}
+	if secret == nil || secret.Auth == nil || len(secret.Auth.ClientToken) == 0 {
+		return token, fmt.Errorf("login to auth/%s did not return a client token", mount)
+	}
+	return secret.Auth.ClientToken, nil
+}
+
+// VerifyLogin logs in to the cluster auth mount with the JWT of a bound service account, the
+// same way applications do, and revokes the token it got again
+func (v *VaultRegister) VerifyLogin(role string, jwt string) (err error) {
+	client, err := v.getClient()
+	if err != nil {
+		return err
+	}
+	// a fresh client keeps the operator token out of the login, the clone only shares the transport.
+	// Cloning goes through api.NewClient, so the environment is dropped again
+	loginClient, err := client.Clone()
+	if err != nil {
+		return err
+	}
+	err = loginClient.SetAddress(v.VaultAddress)
+	if err != nil {
+		return err
+	}
+	v.resetEnvironment(loginClient)
+	token, err := (&KubernetesAuth{Mount: v.Mount, Role: role, JWT: jwt}).Login(loginClient)
+	if err != nil {
+		return err
+	}
+	loginClient.SetToken(token)
+	// the login succeeded, a token which cannot be revoked still expires with its ttl
+	_ = loginClient.Auth().Token().RevokeSelf("")
+	return nil
+}
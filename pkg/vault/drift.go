@@ -0,0 +1,176 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DriftMount  = "mount"
+	DriftConfig = "config"
+	DriftRole   = "role/"
+)
+
+// durationKeys are returned by vault in seconds but written as duration strings
+var durationKeys = map[string]bool{
+	"token_ttl":     true,
+	"token_max_ttl": true,
+	"token_period":  true,
+}
+
+// DetectDrift compares the auth mount, its config and the managed roles against the
+// desired state and returns what is out of sync
+func (v *VaultRegister) DetectDrift() (drift []string, err error) {
+	client, err := v.getClient()
+	if err != nil {
+		return drift, err
+	}
+
+	authMap, err := client.Sys().ListAuth()
+	if err != nil {
+		return drift, err
+	}
+	mount, ok := authMap[v.Mount+"/"]
+	if !ok || mount.Type != "kubernetes" {
+		// nothing below the mount can be compared
+		return []string{DriftMount}, nil
+	}
+
+	config, err := client.Logical().Read("auth/" + v.Mount + "/config")
+	if err != nil {
+		return drift, err
+	}
+	if config == nil || v.configDrifted(config.Data) {
+		drift = append(drift, DriftConfig)
+	}
+
+	for _, role := range v.Roles {
+		secret, err := client.Logical().Read("auth/" + v.Mount + "/role/" + role.Name)
+		if err != nil {
+			return drift, err
+		}
+		if secret == nil || roleDrifted(role, secret.Data) {
+			drift = append(drift, DriftRole+role.Name)
+		}
+	}
+	return drift, nil
+}
+
+// configDrifted compares the readable fields of auth/<mount>/config. The reviewer JWT is
+// never returned by vault, only whether one is set, so callers re-write it with WriteConfig
+func (v *VaultRegister) configDrifted(actual map[string]interface{}) bool {
+	if fmt.Sprint(actual["kubernetes_host"]) != v.K8SHost {
+		return true
+	}
+	if strings.TrimSpace(fmt.Sprint(actual["kubernetes_ca_cert"])) != strings.TrimSpace(v.K8SCACert) {
+		return true
+	}
+	if jwtSet, ok := actual["token_reviewer_jwt_set"].(bool); ok && jwtSet != (len(v.SAToken) != 0) {
+		return true
+	}
+	return false
+}
+
+// roleDrifted compares the desired role with what vault returns. Keys unknown to the
+// running vault version are ignored
+func roleDrifted(role Role, actual map[string]interface{}) bool {
+	for key, want := range roleData(role) {
+		if key == "policies" {
+			if _, ok := actual["token_policies"]; ok {
+				key = "token_policies"
+			}
+		}
+		got, ok := actual[key]
+		if !ok {
+			continue
+		}
+		if !sameValue(key, want, got) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(key string, want interface{}, got interface{}) bool {
+	switch wantValue := want.(type) {
+	case []string:
+		return sameStrings(wantValue, toStrings(got))
+	case int:
+		return toInt64(got) == int64(wantValue)
+	case string:
+		if durationKeys[key] {
+			return durationSeconds(wantValue) == toInt64(got)
+		}
+		return wantValue == fmt.Sprint(got)
+	}
+	return true
+}
+
+func sameStrings(want []string, got []string) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	want = append([]string(nil), want...)
+	got = append([]string(nil), got...)
+	sort.Strings(want)
+	sort.Strings(got)
+	for i := range want {
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func toStrings(value interface{}) (values []string) {
+	switch typed := value.(type) {
+	case []interface{}:
+		for _, item := range typed {
+			values = append(values, fmt.Sprint(item))
+		}
+	case []string:
+		values = typed
+	case string:
+		// vault returns comma separated strings for some older fields
+		for _, item := range strings.Split(typed, ",") {
+			if item = strings.TrimSpace(item); len(item) != 0 {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+func toInt64(value interface{}) int64 {
+	switch typed := value.(type) {
+	case json.Number:
+		number, _ := typed.Int64()
+		return number
+	case int:
+		return int64(typed)
+	case int64:
+		return typed
+	case float64:
+		return int64(typed)
+	case string:
+		number, _ := strconv.ParseInt(typed, 10, 64)
+		return number
+	}
+	return 0
+}
+
+// durationSeconds accepts the same formats as vault: a go duration or plain seconds
+func durationSeconds(duration string) int64 {
+	if seconds, err := strconv.ParseInt(duration, 10, 64); err == nil {
+		return seconds
+	}
+	parsed, err := time.ParseDuration(duration)
+	if err != nil {
+		return -1
+	}
+	return int64(parsed.Seconds())
+}
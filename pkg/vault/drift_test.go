@@ -0,0 +1,197 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRoleDrifted(t *testing.T) {
+	role := Role{
+		Name:            "demo",
+		ServiceAccounts: []string{"external-secrets"},
+		Namespaces:      []string{"kube-external-secrets"},
+		Policies:        []string{"read", "list"},
+		Options:         RoleOptions{TokenTTL: "1h"},
+	}
+	inSync := func() map[string]interface{} {
+		return map[string]interface{}{
+			"bound_service_account_names":      []interface{}{"external-secrets"},
+			"bound_service_account_namespaces": []interface{}{"kube-external-secrets"},
+			"token_policies":                   []interface{}{"list", "read"},
+			"policies":                         []interface{}{"list", "read"},
+			"token_ttl":                        json.Number("3600"),
+			"token_max_ttl":                    json.Number("0"),
+			"token_period":                     json.Number("0"),
+			"token_bound_cidrs":                []interface{}{},
+			"token_num_uses":                   json.Number("0"),
+			"token_type":                       "default",
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(actual map[string]interface{})
+		want   bool
+	}{
+		{
+			name:   "in sync",
+			modify: func(actual map[string]interface{}) {},
+		},
+		{
+			name: "unknown keys from older vault versions are ignored",
+			modify: func(actual map[string]interface{}) {
+				delete(actual, "token_type")
+			},
+		},
+		{
+			name: "policy edited by hand",
+			modify: func(actual map[string]interface{}) {
+				actual["token_policies"] = []interface{}{"root"}
+			},
+			want: true,
+		},
+		{
+			name: "ttl edited by hand",
+			modify: func(actual map[string]interface{}) {
+				actual["token_ttl"] = json.Number("60")
+			},
+			want: true,
+		},
+		{
+			name: "bound namespace added by hand",
+			modify: func(actual map[string]interface{}) {
+				actual["bound_service_account_namespaces"] = []interface{}{"kube-external-secrets", "*"}
+			},
+			want: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := inSync()
+			test.modify(actual)
+			if got := roleDrifted(role, actual); got != test.want {
+				t.Fatalf("roleDrifted() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigDrifted(t *testing.T) {
+	v := &VaultRegister{K8SHost: "https://10.0.0.1:6443", K8SCACert: "ca\n", SAToken: "jwt"}
+
+	inSync := map[string]interface{}{
+		"kubernetes_host":        "https://10.0.0.1:6443",
+		"kubernetes_ca_cert":     "ca",
+		"token_reviewer_jwt_set": true,
+	}
+	if v.configDrifted(inSync) {
+		t.Fatalf("expected config to be in sync")
+	}
+
+	moved := map[string]interface{}{
+		"kubernetes_host":    "https://10.0.0.2:6443",
+		"kubernetes_ca_cert": "ca",
+	}
+	if !v.configDrifted(moved) {
+		t.Fatalf("expected changed kubernetes_host to drift")
+	}
+
+	jwtRemoved := map[string]interface{}{
+		"kubernetes_host":        "https://10.0.0.1:6443",
+		"kubernetes_ca_cert":     "ca",
+		"token_reviewer_jwt_set": false,
+	}
+	if !v.configDrifted(jwtRemoved) {
+		t.Fatalf("expected removed reviewer jwt to drift")
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	var written map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut || req.URL.Path != "/v1/auth/k8s-demo/config" {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		_ = json.NewDecoder(req.Body).Decode(&written)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	v := &VaultRegister{VaultAddress: server.URL, Auth: &TokenAuth{Token: "root"}, Mount: "k8s-demo",
+		K8SHost: "https://10.0.0.1:6443", K8SCACert: "ca", SAToken: "jwt"}
+	if err := v.WriteConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if written["token_reviewer_jwt"] != "jwt" || written["kubernetes_host"] != "https://10.0.0.1:6443" {
+		t.Fatalf("unexpected config %v", written)
+	}
+}
+
+func TestWriteRole(t *testing.T) {
+	var written map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut || req.URL.Path != "/v1/auth/k8s-demo/role/demo" {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		written = nil
+		_ = json.NewDecoder(req.Body).Decode(&written)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	v := &VaultRegister{VaultAddress: server.URL, Auth: &TokenAuth{Token: "root"}, Mount: "k8s-demo"}
+	role := Role{
+		Name:            "demo",
+		ServiceAccounts: []string{"vault-auth"},
+		Namespaces:      []string{"apps", "jobs"},
+		Policies:        []string{"read"},
+		Options: RoleOptions{
+			TokenTTL:        "1h",
+			TokenMaxTTL:     "4h",
+			TokenBoundCIDRs: []string{"10.0.0.0/8"},
+			TokenNumUses:    3,
+			TokenType:       "batch",
+			Audience:        "vault",
+			AliasNameSource: "serviceaccount_name",
+		},
+	}
+	if err := v.WriteRole(role); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"bound_service_account_names":      []interface{}{"vault-auth"},
+		"bound_service_account_namespaces": []interface{}{"apps", "jobs"},
+		"policies":                         []interface{}{"read"},
+		"token_ttl":                        "1h",
+		"token_max_ttl":                    "4h",
+		"token_period":                     "0",
+		"token_bound_cidrs":                []interface{}{"10.0.0.0/8"},
+		"token_num_uses":                   float64(3),
+		"token_type":                       "batch",
+		"audience":                         "vault",
+		"alias_name_source":                "serviceaccount_name",
+	}
+	if !reflect.DeepEqual(written, want) {
+		t.Fatalf("role written as %v, want %v", written, want)
+	}
+
+	// settings dropped from the spec are reset instead of left in vault
+	role.Options = RoleOptions{}
+	if err := v.WriteRole(role); err != nil {
+		t.Fatal(err)
+	}
+	want["token_ttl"] = DefaultTokenTTL
+	want["token_max_ttl"] = "0"
+	want["token_bound_cidrs"] = []interface{}{}
+	want["token_num_uses"] = float64(0)
+	want["token_type"] = "default"
+	want["audience"] = ""
+	delete(want, "alias_name_source")
+	if !reflect.DeepEqual(written, want) {
+		t.Fatalf("role written as %v, want %v", written, want)
+	}
+}
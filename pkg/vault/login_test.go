@@ -0,0 +1,88 @@
+package vault
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestVerifyLogin(t *testing.T) {
+	var revoked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/v1/auth/k8s-demo/login":
+			if req.Header.Get("X-Vault-Token") != "" {
+				t.Errorf("login sent the operator token")
+			}
+			body := map[string]string{}
+			_ = json.NewDecoder(req.Body).Decode(&body)
+			if body["role"] != "demo" || body["jwt"] != "bound-sa-jwt" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.login"}}`))
+		case "/v1/auth/token/revoke-self":
+			revoked = append(revoked, req.Header.Get("X-Vault-Token"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	v := &VaultRegister{VaultAddress: server.URL, Mount: "k8s-demo", Auth: &TokenAuth{Token: "root"}}
+	if err := v.VerifyLogin("demo", "bound-sa-jwt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != "s.login" {
+		t.Fatalf("login token not revoked: %v", revoked)
+	}
+
+	if err := v.VerifyLogin("demo", "other-jwt"); err == nil {
+		t.Fatalf("expected the failed login to be reported")
+	}
+}
+
+func TestVerifyLoginIgnoresEnvironment(t *testing.T) {
+	var loginHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/v1/auth/k8s-demo/login":
+			loginHeaders = req.Header
+			_, _ = w.Write([]byte(`{"auth":{"client_token":"s.login"}}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	for key, value := range map[string]string{
+		"VAULT_TOKEN":     "s.from-env",
+		"VAULT_NAMESPACE": "from-env",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	v := &VaultRegister{VaultAddress: server.URL, Mount: "k8s-demo", Auth: &TokenAuth{Token: "root"}}
+	if err := v.VerifyLogin("demo", "bound-sa-jwt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(loginHeaders.Get("X-Vault-Token")) != 0 || len(loginHeaders["X-Vault-Namespace"]) != 0 {
+		t.Fatalf("login sent token or namespace from the environment: %v", loginHeaders)
+	}
+
+	v = &VaultRegister{VaultAddress: server.URL, Mount: "k8s-demo", Auth: &TokenAuth{Token: "root"},
+		VaultNamespace: "team-a"}
+	if err := v.VerifyLogin("demo", "bound-sa-jwt"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if namespace := loginHeaders.Get("X-Vault-Namespace"); namespace != "team-a" {
+		t.Fatalf("login sent namespace %q, want team-a", namespace)
+	}
+}
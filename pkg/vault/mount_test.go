@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// mountVault fakes the vault endpoints used to manage the auth mount. mounts is the
+// sys/auth listing and calls records every other request as "<method> <path>"
+func mountVault(mounts map[string]interface{}, calls *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if req.Method == http.MethodGet && req.URL.Path == "/v1/sys/auth" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": mounts})
+			return
+		}
+		*calls = append(*calls, req.Method+" "+req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func TestMountOwnership(t *testing.T) {
+	owner := MountOwner{ClusterID: "c1", RegisterUID: "r1", OperatorVersion: "v1"}
+	kubernetesMount := func(description string) map[string]interface{} {
+		return map[string]interface{}{"k8s-demo/": map[string]interface{}{
+			"type": "kubernetes", "description": description}}
+	}
+
+	tests := []struct {
+		name          string
+		mounts        map[string]interface{}
+		adoptUnmarked bool
+		wantOwned     bool
+		wantCall      string
+	}{
+		{
+			name:      "free path is enabled",
+			mounts:    map[string]interface{}{"token/": map[string]interface{}{"type": "token"}},
+			wantOwned: true,
+			wantCall:  "POST /v1/sys/auth/k8s-demo",
+		},
+		{
+			name:      "mount owned by the register is configured",
+			mounts:    kubernetesMount(owner.Description()),
+			wantOwned: true,
+		},
+		{
+			name: "mount created by another operator version is still owned",
+			mounts: kubernetesMount(MountOwner{ClusterID: "c1", RegisterUID: "r1",
+				OperatorVersion: "v0"}.Description()),
+			wantOwned: true,
+		},
+		{
+			name:   "mount of another register is refused",
+			mounts: kubernetesMount(MountOwner{ClusterID: "c1", RegisterUID: "r2"}.Description()),
+		},
+		{
+			name:   "mount of another cluster is refused",
+			mounts: kubernetesMount(MountOwner{ClusterID: "c2", RegisterUID: "r1"}.Description()),
+		},
+		{
+			name:   "unmarked mount is refused",
+			mounts: kubernetesMount("team auth backend"),
+		},
+		{
+			name:          "unmarked mount created by an older version is claimed",
+			mounts:        kubernetesMount(""),
+			adoptUnmarked: true,
+			wantOwned:     true,
+			wantCall:      "POST /v1/sys/mounts/auth/k8s-demo/tune",
+		},
+		{
+			name:          "mount of another type is refused",
+			mounts:        map[string]interface{}{"k8s-demo/": map[string]interface{}{"type": "approle"}},
+			adoptUnmarked: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls []string
+			server := httptest.NewServer(mountVault(test.mounts, &calls))
+			defer server.Close()
+
+			v := &VaultRegister{VaultAddress: server.URL, Mount: "k8s-demo", Owner: owner,
+				AdoptUnmarked: test.adoptUnmarked, Auth: &TokenAuth{Token: "root"}}
+			_, err := v.RegisterCluster()
+			var notOwned *MountNotOwnedError
+			if test.wantOwned == errors.As(err, &notOwned) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantOwned && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(test.wantCall) != 0 && (len(calls) == 0 || calls[0] != test.wantCall) {
+				t.Fatalf("calls = %v, want %s first", calls, test.wantCall)
+			}
+			if !test.wantOwned && len(calls) != 0 {
+				t.Fatalf("mount not owned but vault was changed: %v", calls)
+			}
+		})
+	}
+}
+
+func TestUnregisterClusterOwnership(t *testing.T) {
+	owner := MountOwner{ClusterID: "c1", RegisterUID: "r1"}
+	other := MountOwner{ClusterID: "c1", RegisterUID: "r2"}
+
+	var calls []string
+	server := httptest.NewServer(mountVault(map[string]interface{}{"k8s-demo/": map[string]interface{}{
+		"type": "kubernetes", "description": other.Description()}}, &calls))
+	defer server.Close()
+
+	v := &VaultRegister{VaultAddress: server.URL, Mount: "k8s-demo", Owner: owner, Auth: &TokenAuth{Token: "root"}}
+	var notOwned *MountNotOwnedError
+	if err := v.UnregisterCluster(); !errors.As(err, &notOwned) {
+		t.Fatalf("expected a MountNotOwnedError, got %v", err)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("mount of another register was changed: %v", calls)
+	}
+
+	v = &VaultRegister{VaultAddress: server.URL, Mount: "k8s-demo", Owner: other, Auth: &TokenAuth{Token: "root"}}
+	if err := v.UnregisterCluster(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(calls) != 1 || calls[0] != "DELETE /v1/sys/auth/k8s-demo" {
+		t.Fatalf("calls = %v, want the mount disabled", calls)
+	}
+}
@@ -0,0 +1,61 @@
+package vault
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+)
+
+const ownerMarker = "managed by vault-glue-operator"
+
+// MountOwner identifies the Register an auth mount was created for. It is written to the
+// mount description as vault auth mounts have no other place for metadata
+type MountOwner struct {
+	ClusterID       string
+	RegisterUID     string
+	OperatorVersion string //informational, mounts stay owned across upgrades
+}
+
+// Description is the mount description recording the owner
+func (o MountOwner) Description() string {
+	return fmt.Sprintf("%s cluster=%s register=%s version=%s", ownerMarker, o.ClusterID, o.RegisterUID,
+		o.OperatorVersion)
+}
+
+// parseMountOwner reads the owner from a mount description. marked is false when the
+// description was not written by the operator
+func parseMountOwner(description string) (owner MountOwner, marked bool) {
+	if !strings.HasPrefix(description, ownerMarker+" ") {
+		return owner, false
+	}
+	for _, field := range strings.Fields(strings.TrimPrefix(description, ownerMarker)) {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "cluster":
+			owner.ClusterID = parts[1]
+		case "register":
+			owner.RegisterUID = parts[1]
+		case "version":
+			owner.OperatorVersion = parts[1]
+		}
+	}
+	return owner, true
+}
+
+// owns checks the ownership marker of an existing mount. A kubernetes mount without any
+// marker is only owned when AdoptUnmarked is set
+func (v *VaultRegister) owns(mount *api.AuthMount) (owned bool, unmarked bool) {
+	if mount == nil || mount.Type != "kubernetes" {
+		return false, false
+	}
+	owner, marked := parseMountOwner(mount.Description)
+	if !marked {
+		return v.AdoptUnmarked, true
+	}
+	return len(owner.RegisterUID) != 0 && owner.ClusterID == v.Owner.ClusterID &&
+		owner.RegisterUID == v.Owner.RegisterUID, false
+}
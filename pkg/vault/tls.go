@@ -0,0 +1,38 @@
+package vault
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// configureTLS applies the tls settings used to talk to vault. Server certificates are
+// verified against the supplied CA, or the system roots when no CA is supplied,
+// unless Insecure is set
+func (v *VaultRegister) configureTLS(config *tls.Config) (err error) {
+	config.MinVersion = tls.VersionTLS12
+	config.ServerName = v.TLSServerName
+
+	if v.Insecure {
+		config.InsecureSkipVerify = true
+	} else if len(v.CACert) != 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(v.CACert) {
+			return fmt.Errorf("unable to parse vault CA certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	switch {
+	case len(v.ClientCert) != 0 && len(v.ClientKey) != 0:
+		clientCert, err := tls.X509KeyPair(v.ClientCert, v.ClientKey)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+	case len(v.ClientCert) != 0 || len(v.ClientKey) != 0:
+		return fmt.Errorf("both client cert and client key must be provided")
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeVault stands in for the vault server and only answers sys/auth listing
+func fakeVault() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"token/":{"type":"token"}}}`))
+	})
+}
+
+func serverCAPEM(server *httptest.Server) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+}
+
+func listAuth(v *VaultRegister) error {
+	client, err := v.createClient()
+	if err != nil {
+		return err
+	}
+	// tls failures are not worth retrying against the fake vault
+	client.SetMaxRetries(0)
+	_, err = client.Sys().ListAuth()
+	return err
+}
+
+func TestCreateClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(fakeVault())
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		v       VaultRegister
+		wantErr bool
+	}{
+		{
+			name:    "verify with system roots fails",
+			v:       VaultRegister{},
+			wantErr: true,
+		},
+		{
+			name: "verify with supplied CA",
+			v:    VaultRegister{CACert: serverCAPEM(server)},
+		},
+		{
+			name: "insecure skips verification",
+			v:    VaultRegister{Insecure: true},
+		},
+		{
+			name:    "invalid CA fails closed",
+			v:       VaultRegister{CACert: []byte("not a pem")},
+			wantErr: true,
+		},
+		{
+			name: "server name override matching the certificate",
+			v:    VaultRegister{CACert: serverCAPEM(server), TLSServerName: "example.com"},
+		},
+		{
+			name:    "server name override not matching the certificate",
+			v:       VaultRegister{CACert: serverCAPEM(server), TLSServerName: "vault.invalid"},
+			wantErr: true,
+		},
+		{
+			name:    "client cert without key",
+			v:       VaultRegister{CACert: serverCAPEM(server), ClientCert: []byte("cert")},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := test.v
+			v.VaultAddress = server.URL
+			v.Auth = &TokenAuth{Token: "root"}
+			err := listAuth(&v)
+			if test.wantErr && err == nil {
+				t.Fatalf("expected an error")
+			}
+			if !test.wantErr && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreateClientIgnoresEnvironment(t *testing.T) {
+	var namespace []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		namespace = req.Header["X-Vault-Namespace"]
+		fakeVault().ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	for key, value := range map[string]string{
+		"VAULT_SKIP_VERIFY": "true",
+		"VAULT_NAMESPACE":   "from-env",
+	} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+
+	v := &VaultRegister{VaultAddress: server.URL, Auth: &TokenAuth{Token: "root"}}
+	if err := listAuth(v); err == nil {
+		t.Fatalf("expected VAULT_SKIP_VERIFY to be ignored")
+	}
+
+	v.CACert = serverCAPEM(server)
+	if err := listAuth(v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(namespace) != 0 {
+		t.Fatalf("namespace %v sent from the environment", namespace)
+	}
+}
+
+func TestCreateClientMutualTLS(t *testing.T) {
+	caCert, caKey := generateCA(t)
+	clientCert, clientKey := generateClientCert(t, caCert, caKey)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	server := httptest.NewUnstartedServer(fakeVault())
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	server.StartTLS()
+	defer server.Close()
+
+	v := &VaultRegister{
+		VaultAddress: server.URL,
+		CACert:       serverCAPEM(server),
+		Auth:         &TokenAuth{Token: "root"},
+	}
+	if err := listAuth(v); err == nil {
+		t.Fatalf("expected an error without a client certificate")
+	}
+
+	v.ClientCert = clientCert
+	v.ClientKey = clientKey
+	if err := listAuth(v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func generateCA(t *testing.T) (cert *x509.Certificate, key *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func generateClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) (certPEM []byte, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "vault-glue-operator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
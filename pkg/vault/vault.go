@@ -1,81 +1,220 @@
 package vault
 
 import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 )
 
 type VaultRegister struct {
-	SAToken      string //base64 encoded JWT Token
-	K8SCACert    string //base64 encoded CA cert
-	Insecure     bool
-	K8SHost      string
-	Mount        string //dynamically generated
-	SAName       string
-	Namespace    string
-	Policy       []string
-	VaultToken   string
-	VaultAddress string
-	RoleName     string
-}
-
-//RegisterCluster will perform vault auth setup for this cluster
-func (v *VaultRegister) RegisterCluster(skipAuth bool) (authEnabled bool, err error) {
-	client, err := v.createClient()
+	SAToken        string    //base64 encoded JWT Token
+	SATokenExpiry  time.Time //expiry of a short lived SAToken, zero for token secrets
+	K8SCACert      string    //base64 encoded CA cert
+	Insecure       bool      //skip verification of the vault server certificate
+	CACert         []byte    //PEM encoded CA bundle for the vault server
+	ClientCert     []byte    //PEM encoded client cert for mTLS
+	ClientKey      []byte    //PEM encoded client key for mTLS
+	TLSServerName  string
+	K8SHost        string
+	Mount          string     //derived from the Register unless set in the spec
+	Owner          MountOwner //recorded in the mount description to claim the mount
+	AdoptUnmarked  bool       //claim a mount without ownership marker, created by older versions
+	SAName         string
+	Namespace      string
+	Auth           Authenticator //used by the operator to login to vault
+	VaultAddress   string
+	VaultNamespace string //sent as X-Vault-Namespace on every request
+	Roles          []Role //roles managed on the auth mount
+	client         *api.Client
+}
+
+// Role is a kubernetes auth role on the cluster auth mount
+type Role struct {
+	Name            string
+	ServiceAccounts []string
+	Namespaces      []string
+	Policies        []string
+	Options         RoleOptions
+}
+
+// RoleOptions are the token settings written to the vault role
+type RoleOptions struct {
+	TokenTTL        string
+	TokenMaxTTL     string
+	TokenPeriod     string
+	TokenBoundCIDRs []string
+	TokenNumUses    int
+	TokenType       string
+	Audience        string
+	AliasNameSource string
+}
+
+const DefaultTokenTTL = "24h"
+
+// MountNotOwnedError is returned when the auth mount exists but was not created for this owner
+type MountNotOwnedError struct {
+	Mount       string
+	Description string
+}
+
+func (e *MountNotOwnedError) Error() string {
+	return fmt.Sprintf("auth mount %s is not owned by this Register (description %q)", e.Mount, e.Description)
+}
+
+// RegisterCluster will perform vault auth setup for this cluster. The mount is enabled when
+// missing and only configured when owned by v.Owner. Roles are managed with WriteRole
+func (v *VaultRegister) RegisterCluster() (enabled bool, err error) {
+	client, err := v.getClient()
 	if err != nil {
-		return authEnabled, err
+		return enabled, err
 	}
 
-	if !skipAuth {
-		err = client.Sys().EnableAuthWithOptions(v.Mount, &api.EnableAuthOptions{Type: "kubernetes"})
+	authMap, err := client.Sys().ListAuth()
+	if err != nil {
+		return enabled, err
+	}
+	if existing, ok := authMap[v.Mount+"/"]; ok {
+		owned, unmarked := v.owns(existing)
+		if owned && unmarked {
+			// claim mounts created before ownership was recorded
+			description := v.Owner.Description()
+			err = client.Sys().TuneMount("auth/"+v.Mount, api.MountConfigInput{Description: &description})
+			if err != nil {
+				return enabled, err
+			}
+		}
+		if !owned {
+			return enabled, &MountNotOwnedError{Mount: v.Mount, Description: existing.Description}
+		}
+	} else {
+		err = client.Sys().EnableAuthWithOptions(v.Mount, &api.EnableAuthOptions{
+			Type:        "kubernetes",
+			Description: v.Owner.Description(),
+		})
 		if err != nil {
-			return authEnabled, err
+			return enabled, err
 		}
+		enabled = true
+	}
+
+	return enabled, v.writeConfig(client)
+}
+
+// WriteConfig re-writes auth/<mount>/config of a mount already set up by RegisterCluster
+func (v *VaultRegister) WriteConfig() (err error) {
+	client, err := v.getClient()
+	if err != nil {
+		return err
 	}
+	return v.writeConfig(client)
+}
 
-	authEnabled = true
+func (v *VaultRegister) writeConfig(client *api.Client) (err error) {
 	configData := make(map[string]interface{})
 	configData["kubernetes_host"] = v.K8SHost
 	configData["token_reviewer_jwt"] = v.SAToken
 	configData["kubernetes_ca_cert"] = v.K8SCACert
 	_, err = client.Logical().Write("auth/"+v.Mount+"/config", configData)
+	return err
+}
 
+// WriteRole will create or update the role in place on the auth mount
+func (v *VaultRegister) WriteRole(role Role) (err error) {
+	client, err := v.getClient()
 	if err != nil {
-		return authEnabled, err
+		return err
 	}
+	// perform role binding //
+	_, err = client.Logical().Write("auth/"+v.Mount+"/role/"+role.Name, roleData(role))
+	return err
+}
 
-	roleData := make(map[string]interface{})
-	roleData["bound_service_account_names"] = v.SAName
-	roleData["bound_service_account_namespaces"] = v.Namespace
-	roleData["policies"] = v.Policy
-	roleData["ttl"] = "24h"
+// DeleteRole removes a role which is no longer managed from the auth mount
+func (v *VaultRegister) DeleteRole(name string) (err error) {
+	client, err := v.getClient()
+	if err != nil {
+		return err
+	}
+	_, err = client.Logical().Delete("auth/" + v.Mount + "/role/" + name)
+	return err
+}
 
-	// perform role binding //
-	_, err = client.Logical().Write("auth/"+v.Mount+"/role/"+v.RoleName, roleData)
-	return authEnabled, err
+// roleData always sends every token setting so fields dropped from the spec are reset
+func roleData(role Role) (roleData map[string]interface{}) {
+	roleData = make(map[string]interface{})
+	roleData["bound_service_account_names"] = role.ServiceAccounts
+	roleData["bound_service_account_namespaces"] = role.Namespaces
+	roleData["policies"] = role.Policies
+	roleData["token_ttl"] = role.Options.TokenTTL
+	if len(role.Options.TokenTTL) == 0 {
+		roleData["token_ttl"] = DefaultTokenTTL
+	}
+	roleData["token_max_ttl"] = durationOrZero(role.Options.TokenMaxTTL)
+	roleData["token_period"] = durationOrZero(role.Options.TokenPeriod)
+	roleData["token_bound_cidrs"] = role.Options.TokenBoundCIDRs
+	if role.Options.TokenBoundCIDRs == nil {
+		roleData["token_bound_cidrs"] = []string{}
+	}
+	roleData["token_num_uses"] = role.Options.TokenNumUses
+	roleData["token_type"] = role.Options.TokenType
+	if len(role.Options.TokenType) == 0 {
+		roleData["token_type"] = "default"
+	}
+	roleData["audience"] = role.Options.Audience
+	// alias_name_source is left to the vault default unless requested
+	if len(role.Options.AliasNameSource) != 0 {
+		roleData["alias_name_source"] = role.Options.AliasNameSource
+	}
+	return roleData
 }
 
 // UnregisterCluster will disable the associated k8s backend
 func (v *VaultRegister) UnregisterCluster() (err error) {
-	client, err := v.createClient()
+	client, err := v.getClient()
 	if err != nil {
 		return err
 	}
 
 	authMap, err := client.Sys().ListAuth()
-	if _, ok := authMap[v.Mount+"/"]; ok {
+	if existing, ok := authMap[v.Mount+"/"]; ok {
+		if owned, _ := v.owns(existing); !owned {
+			return &MountNotOwnedError{Mount: v.Mount, Description: existing.Description}
+		}
 		err = client.Sys().DisableAuth(v.Mount)
 	}
 
 	return err
 }
 
+// getClient logs in once and reuses the client for the lifetime of the request
+func (v *VaultRegister) getClient() (client *api.Client, err error) {
+	if v.client != nil {
+		return v.client, nil
+	}
+	v.client, err = v.createClient()
+	if err != nil {
+		v.client = nil
+	}
+	return v.client, err
+}
+
 func (v *VaultRegister) createClient() (client *api.Client, err error) {
-	config := &api.Config{}
-	tlsConfig := &api.TLSConfig{Insecure: true}
-	err = config.ConfigureTLS(tlsConfig)
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return client, config.Error
+	}
+	// tls settings come from the Register alone, never from VAULT_CACERT, VAULT_SKIP_VERIFY
+	// and the like read by DefaultConfig
+	tlsConfig := &tls.Config{}
+	err = v.configureTLS(tlsConfig)
 	if err != nil {
 		return client, err
 	}
+	config.HttpClient.Transport.(*http.Transport).TLSClientConfig = tlsConfig
 	client, err = api.NewClient(config)
 	if err != nil {
 		return client, err
@@ -84,6 +223,34 @@ func (v *VaultRegister) createClient() (client *api.Client, err error) {
 	if err != nil {
 		return client, err
 	}
-	client.SetToken(v.VaultToken)
+	if v.Auth == nil {
+		return client, fmt.Errorf("no vault authenticator configured")
+	}
+	v.resetEnvironment(client)
+	token, err := v.Auth.Login(client)
+	if err != nil {
+		return client, err
+	}
+	client.SetToken(token)
 	return client, nil
 }
+
+// resetEnvironment drops the token and namespace api.NewClient reads from VAULT_TOKEN and
+// VAULT_NAMESPACE, the namespace is taken from the Register alone
+func (v *VaultRegister) resetEnvironment(client *api.Client) {
+	client.ClearToken()
+	if len(v.VaultNamespace) != 0 {
+		client.SetNamespace(v.VaultNamespace)
+		return
+	}
+	headers := client.Headers()
+	headers.Del(consts.NamespaceHeaderName)
+	client.SetHeaders(headers)
+}
+
+func durationOrZero(duration string) string {
+	if len(duration) == 0 {
+		return "0"
+	}
+	return duration
+}